@@ -2,15 +2,30 @@ package config
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
 	TelegramBotToken string `name:"telegram"`
 	TelegramBotAdmin string `name:"botadmin"`
+	SessionDir       string `name:"sessions"`
+	PacksFile        string `name:"packs"`
+
+	// Idle sessions are cleared after SessionTTL, with a warning SessionWarning before
+	SessionTTL         time.Duration `name:"session_ttl"`
+	SessionWarning     time.Duration `name:"session_warning"`
+	MaxSessionStickers int           `name:"max_session_stickers"`
+	MaxSessions        int           `name:"max_sessions"`
+
+	// Chat the users file of the single game played before every chat had
+	// its own moves to, 0 for the first chat that plays
+	GameChat int64 `name:"game_chat"`
 }
 
 var cfg Config
@@ -18,6 +33,13 @@ var cfg Config
 func init() {
 	cfg = Config{
 		TelegramBotToken: "",
+		SessionDir:       "data/sessions",
+		PacksFile:        "data/packs.json",
+
+		SessionTTL:         72 * time.Hour,
+		SessionWarning:     12 * time.Hour,
+		MaxSessionStickers: 600,
+		MaxSessions:        10000,
 	}
 
 	loadAPIKeys()
@@ -68,10 +90,41 @@ func loadAPIKeys() (string, error) {
 		for i := 0; i < typ.NumField(); i++ {
 			tag := typ.Field(i).Tag.Get("name")
 			if tag == fieldName {
-				val.Field(i).SetString(fieldValue)
+				if err := setField(val.Field(i), fieldValue); err != nil {
+					log.Printf("Invalid value for %s: %v", fieldName, err)
+				}
 			}
 		}
 	}
 
 	return apiKey, nil
 }
+
+// setField parses value into a string, int, int64 or time.Duration field.
+func setField(field reflect.Value, value string) error {
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(value)
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+	return nil
+}
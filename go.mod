@@ -2,6 +2,11 @@ module github.com/ws117z5/telegram_bot
 
 go 1.25.0
 
+require (
+	github.com/mymmrac/telego v1.3.1
+	golang.org/x/image v0.33.0
+)
+
 require (
 	github.com/andybalholm/brotli v1.2.0 // indirect
 	github.com/bytedance/gopkg v0.1.3 // indirect
@@ -14,7 +19,6 @@ require (
 	github.com/klauspost/cpuid/v2 v2.2.9 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
-	github.com/mymmrac/telego v1.3.1 // indirect
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasthttp v1.67.0 // indirect
@@ -22,4 +26,5 @@ require (
 	github.com/ws117z5/clipboard v0.0.0-20251023173728-5d37087abaa4 // indirect
 	golang.org/x/arch v0.0.0-20210923205945-b76863e36670 // indirect
 	golang.org/x/sys v0.36.0 // indirect
+	golang.org/x/text v0.31.0 // indirect
 )
@@ -0,0 +1,443 @@
+package telegram_game
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/ws117z5/telegram_bot/functions"
+)
+
+// Poll options in the order they are offered, VOTE_NONE counts the users
+// who didn't vote
+const (
+	VOTE_YES = iota
+	VOTE_NO
+	VOTE_NONE
+)
+
+// Phase is where a game is in its round: idle until the first poll or after
+// a round was aborted, polling while votes are taken, closed once the poll
+// ended. A closed game may be opened again for the next round.
+type Phase int
+
+const (
+	PhaseIdle Phase = iota
+	PhasePolling
+	PhaseClosed
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseIdle:
+		return "idle"
+	case PhasePolling:
+		return "polling"
+	case PhaseClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("Phase(%d)", int(p))
+}
+
+// TransitionError is returned for an event the current phase doesn't allow.
+type TransitionError struct {
+	From  Phase
+	Event string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("can't %s while the game is %s", e.Event, e.From)
+}
+
+var (
+	ErrUnknownUser   = errors.New("user is not in the game")
+	ErrAlreadyJoined = errors.New("user is already in the game")
+	ErrInvalidOption = errors.New("invalid vote option")
+)
+
+// Engine holds the state of a game. Every method takes the lock, so it may
+// be used from the update loop and the observer goroutine at once.
+type Engine struct {
+	mu    sync.Mutex
+	phase Phase
+
+	// Usernames without the leading @
+	users     []string
+	votes     map[string]byte
+	voteCount [3]int
+
+	// Per user counts of rounds answered yes, no or not at all
+	stats map[string][3]int
+
+	pollID    string
+	messageID int
+
+	startTime time.Time
+	endTime   time.Time
+
+	// Users who don't want to be reminded to vote
+	quiet map[string]bool
+
+	// Stops the timers of the current round
+	stopObserver func()
+}
+
+// NewEngine creates an idle game for users, starting from the given stats.
+func NewEngine(users []string, stats map[string][3]int) *Engine {
+	e := &Engine{
+		users: slices.Clone(users),
+		votes: make(map[string]byte, len(users)),
+		stats: make(map[string][3]int, len(users)),
+		quiet: make(map[string]bool),
+	}
+	for _, u := range e.users {
+		e.stats[u] = stats[u]
+	}
+	e.resetVotes()
+	return e
+}
+
+// LoadEngine creates an idle game from a users file, one "name yes no none"
+// line per user, followed by "quiet" for users who opted out of reminders.
+func LoadEngine(path string) (*Engine, error) {
+	lines, err := ReadLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var users, quiet []string
+	stats := make(map[string][3]int)
+	for _, line := range lines {
+		data := strings.Fields(line)
+		if len(data) == 0 {
+			continue
+		}
+		name := strings.TrimPrefix(data[0], "@")
+		users = append(users, name)
+
+		var counts [3]int
+		for i := 1; i < len(data) && i <= 3; i++ {
+			if _, err := fmt.Sscan(data[i], &counts[i-1]); err != nil {
+				return nil, fmt.Errorf("stats of %s: %w", name, err)
+			}
+		}
+		stats[name] = counts
+		if len(data) > 4 && data[4] == "quiet" {
+			quiet = append(quiet, name)
+		}
+	}
+
+	e := NewEngine(users, stats)
+	for _, u := range quiet {
+		e.quiet[u] = true
+	}
+	return e, nil
+}
+
+// WriteStats saves the users and their stats in the format LoadEngine reads.
+func (e *Engine) WriteStats(path string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	lines := make([]string, 0, len(e.users))
+	for _, u := range e.users {
+		stats := e.stats[u]
+		line := fmt.Sprintf("%s %d %d %d", u, stats[VOTE_YES], stats[VOTE_NO], stats[VOTE_NONE])
+		if e.quiet[u] {
+			line += " quiet"
+		}
+		lines = append(lines, line)
+	}
+	return WriteLines(lines, path)
+}
+
+// expect returns a TransitionError unless the game is in one of phases.
+// The caller must hold e.mu.
+func (e *Engine) expect(event string, phases ...Phase) error {
+	if !slices.Contains(phases, e.phase) {
+		return &TransitionError{From: e.phase, Event: event}
+	}
+	return nil
+}
+
+func (e *Engine) resetVotes() {
+	for _, u := range e.users {
+		e.votes[u] = VOTE_NONE
+	}
+	e.voteCount = [3]int{VOTE_NONE: len(e.users)}
+}
+
+// Open starts a round that takes votes until endTime.
+func (e *Engine) Open(now, endTime time.Time) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.expect("start a poll", PhaseIdle, PhaseClosed); err != nil {
+		return err
+	}
+	if !endTime.After(now) {
+		return fmt.Errorf("the poll would end at %s, which has passed", endTime.Format("15:04"))
+	}
+
+	e.resetVotes()
+	e.phase = PhasePolling
+	e.pollID = ""
+	e.messageID = 0
+	e.startTime = now
+	e.endTime = endTime
+	return nil
+}
+
+// SetEndTime moves the end of the running round.
+func (e *Engine) SetEndTime(now, endTime time.Time) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.expect("move the end of the poll", PhasePolling); err != nil {
+		return err
+	}
+	if !endTime.After(now) {
+		return fmt.Errorf("the poll would end at %s, which has passed", endTime.Format("15:04"))
+	}
+	e.endTime = endTime
+	return nil
+}
+
+// AttachPoll records the poll message of the round so its answers are
+// recognised.
+func (e *Engine) AttachPoll(pollID string, messageID int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.expect("attach a poll", PhasePolling); err != nil {
+		return err
+	}
+	e.pollID = pollID
+	e.messageID = messageID
+	return nil
+}
+
+// IsPoll reports whether pollID is the poll of the running round.
+func (e *Engine) IsPoll(pollID string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.phase == PhasePolling && e.pollID == pollID
+}
+
+// Vote sets the answer of a user, VOTE_NONE withdraws it.
+func (e *Engine) Vote(username string, option int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.expect("vote", PhasePolling); err != nil {
+		return err
+	}
+	current, ok := e.votes[username]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownUser, username)
+	}
+	if option < VOTE_YES || option > VOTE_NONE {
+		return fmt.Errorf("%w: %d", ErrInvalidOption, option)
+	}
+
+	e.voteCount[current]--
+	e.voteCount[option]++
+	e.votes[username] = byte(option)
+	return nil
+}
+
+// Join adds a user to the roster. Joining while polling counts them as not
+// voted yet.
+func (e *Engine) Join(username string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if slices.Contains(e.users, username) {
+		return fmt.Errorf("%w: %s", ErrAlreadyJoined, username)
+	}
+	e.users = append(e.users, username)
+	e.votes[username] = VOTE_NONE
+	e.voteCount[VOTE_NONE]++
+	if _, ok := e.stats[username]; !ok {
+		e.stats[username] = [3]int{}
+	}
+	return nil
+}
+
+// Leave removes a user from the roster along with their vote and stats.
+func (e *Engine) Leave(username string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	i := slices.Index(e.users, username)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrUnknownUser, username)
+	}
+	e.users = slices.Delete(e.users, i, i+1)
+	e.voteCount[e.votes[username]]--
+	delete(e.votes, username)
+	delete(e.stats, username)
+	delete(e.quiet, username)
+	return nil
+}
+
+// SetQuiet turns the reminders of a user off or back on.
+func (e *Engine) SetQuiet(username string, quiet bool) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !slices.Contains(e.users, username) {
+		return fmt.Errorf("%w: %s", ErrUnknownUser, username)
+	}
+	if quiet {
+		e.quiet[username] = true
+	} else {
+		delete(e.quiet, username)
+	}
+	return nil
+}
+
+// Close ends the round, counts every answer into the stats and stops its
+// timers.
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.expect("close the poll", PhasePolling); err != nil {
+		return err
+	}
+
+	for _, u := range e.users {
+		stats := e.stats[u]
+		stats[e.votes[u]]++
+		e.stats[u] = stats
+	}
+
+	e.phase = PhaseClosed
+	if e.stopObserver != nil {
+		e.stopObserver()
+		e.stopObserver = nil
+	}
+	return nil
+}
+
+// Abort ends a round that never got going, like one whose poll couldn't be
+// posted. The game is idle again and nothing is counted into the stats.
+func (e *Engine) Abort() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.expect("abort the poll", PhasePolling); err != nil {
+		return err
+	}
+
+	e.resetVotes()
+	e.phase = PhaseIdle
+	e.pollID = ""
+	e.messageID = 0
+	if e.stopObserver != nil {
+		e.stopObserver()
+		e.stopObserver = nil
+	}
+	return nil
+}
+
+// Phase returns the current phase.
+func (e *Engine) Phase() Phase {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.phase
+}
+
+// Counts returns how many users voted yes, no or not at all.
+func (e *Engine) Counts() [3]int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.voteCount
+}
+
+// StartTime returns when the current round started.
+func (e *Engine) StartTime() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.startTime
+}
+
+// EndTime returns when the current round ends.
+func (e *Engine) EndTime() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.endTime
+}
+
+// Stats returns a copy of the per user stats.
+func (e *Engine) Stats() map[string][3]int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ret := make(map[string][3]int, len(e.stats))
+	for u, stats := range e.stats {
+		ret[u] = stats
+	}
+	return ret
+}
+
+// Users returns the usernames of everyone in the game.
+func (e *Engine) Users() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.Clone(e.users)
+}
+
+func (e *Engine) withVote(vote byte) []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ret := []string{}
+	for _, u := range e.users {
+		if e.votes[u] == vote {
+			ret = append(ret, u)
+		}
+	}
+	return ret
+}
+
+func (e *Engine) getIgnored() []string {
+	return e.withVote(VOTE_NONE)
+}
+
+func (e *Engine) getVotedYes() []string {
+	return e.withVote(VOTE_YES)
+}
+
+func (e *Engine) getVotedNo() []string {
+	return e.withVote(VOTE_NO)
+}
+
+// getReminded returns the users who haven't voted and want reminders.
+func (e *Engine) getReminded() []string {
+	ignored := e.getIgnored()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.DeleteFunc(ignored, func(u string) bool { return e.quiet[u] })
+}
+
+// observe registers stop to be called when the round is closed, calling
+// the one registered before. It must be called while polling.
+func (e *Engine) observe(stop func()) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.expect("watch the poll", PhasePolling); err != nil {
+		return err
+	}
+	if e.stopObserver != nil {
+		e.stopObserver()
+	}
+	e.stopObserver = stop
+	return nil
+}
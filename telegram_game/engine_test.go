@@ -0,0 +1,229 @@
+package telegram_game
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC)
+
+// openEngine returns a game of users polling until 23:00.
+func openEngine(t *testing.T, users ...string) *Engine {
+	t.Helper()
+
+	e := NewEngine(users, nil)
+	if err := e.Open(testNow, testNow.Add(5*time.Hour)); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	return e
+}
+
+func checkCounts(t *testing.T, e *Engine, want [3]int) {
+	t.Helper()
+
+	if got := e.Counts(); got != want {
+		t.Errorf("Counts() = %v, want %v", got, want)
+	}
+}
+
+func TestEngineRounds(t *testing.T) {
+	e := NewEngine([]string{"ann", "bob"}, map[string][3]int{"ann": {1, 2, 3}})
+	if got := e.Phase(); got != PhaseIdle {
+		t.Fatalf("new game is %s, want idle", got)
+	}
+
+	if err := e.Open(testNow, testNow.Add(time.Hour)); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	if got := e.Phase(); got != PhasePolling {
+		t.Fatalf("opened game is %s, want polling", got)
+	}
+	if err := e.Vote("ann", VOTE_YES); err != nil {
+		t.Fatalf("Vote() = %v", err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	if got := e.Phase(); got != PhaseClosed {
+		t.Fatalf("closed game is %s, want closed", got)
+	}
+
+	stats := e.Stats()
+	if stats["ann"] != [3]int{2, 2, 3} || stats["bob"] != [3]int{0, 0, 1} {
+		t.Errorf("Stats() after a round = %v", stats)
+	}
+
+	// The next round starts without the votes of the last one
+	next := testNow.Add(24 * time.Hour)
+	if err := e.Open(next, next.Add(time.Hour)); err != nil {
+		t.Fatalf("Open() after Close() = %v", err)
+	}
+	if got := e.Phase(); got != PhasePolling {
+		t.Fatalf("reopened game is %s, want polling", got)
+	}
+	checkCounts(t, e, [3]int{VOTE_NONE: 2})
+	if !e.StartTime().Equal(next) {
+		t.Errorf("StartTime() = %s, want %s", e.StartTime(), next)
+	}
+}
+
+func TestEngineTransitionErrors(t *testing.T) {
+	closed := openEngine(t, "ann")
+	if err := closed.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	games := map[Phase]*Engine{
+		PhaseIdle:   NewEngine([]string{"ann"}, nil),
+		PhaseClosed: closed,
+	}
+	events := map[string]func(e *Engine) error{
+		"Vote":       func(e *Engine) error { return e.Vote("ann", VOTE_YES) },
+		"AttachPoll": func(e *Engine) error { return e.AttachPoll("poll", 1) },
+		"SetEndTime": func(e *Engine) error { return e.SetEndTime(testNow, testNow.Add(time.Hour)) },
+		"Close":      func(e *Engine) error { return e.Close() },
+		"Abort":      func(e *Engine) error { return e.Abort() },
+	}
+
+	for phase, e := range games {
+		for name, event := range events {
+			err := event(e)
+
+			var terr *TransitionError
+			if !errors.As(err, &terr) {
+				t.Errorf("%s while %s = %v, want a *TransitionError", name, phase, err)
+				continue
+			}
+			if terr.From != phase {
+				t.Errorf("%s while %s: error is from %s", name, phase, terr.From)
+			}
+			if got := e.Phase(); got != phase {
+				t.Errorf("%s while %s moved the game to %s", name, phase, got)
+			}
+		}
+	}
+}
+
+func TestEngineWithdrawVote(t *testing.T) {
+	e := openEngine(t, "ann", "bob", "cid")
+
+	for _, vote := range []struct {
+		user   string
+		option int
+	}{
+		{"ann", VOTE_YES},
+		{"bob", VOTE_NO},
+		{"cid", VOTE_YES},
+		{"ann", VOTE_NONE},
+	} {
+		if err := e.Vote(vote.user, vote.option); err != nil {
+			t.Fatalf("Vote(%s, %d) = %v", vote.user, vote.option, err)
+		}
+	}
+	checkCounts(t, e, [3]int{VOTE_YES: 1, VOTE_NO: 1, VOTE_NONE: 1})
+
+	if err := e.Vote("dan", VOTE_YES); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("Vote() of a stranger = %v, want ErrUnknownUser", err)
+	}
+	if err := e.Vote("bob", 7); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("Vote() of option 7 = %v, want ErrInvalidOption", err)
+	}
+	checkCounts(t, e, [3]int{VOTE_YES: 1, VOTE_NO: 1, VOTE_NONE: 1})
+
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	if stats := e.Stats(); stats["ann"] != [3]int{VOTE_NONE: 1} {
+		t.Errorf("stats of a withdrawn vote = %v, want counted as not voted", stats["ann"])
+	}
+}
+
+func TestEngineJoinLeaveMidRound(t *testing.T) {
+	e := openEngine(t, "ann", "bob")
+	if err := e.Vote("ann", VOTE_YES); err != nil {
+		t.Fatalf("Vote() = %v", err)
+	}
+
+	if err := e.Join("cid"); err != nil {
+		t.Fatalf("Join() = %v", err)
+	}
+	checkCounts(t, e, [3]int{VOTE_YES: 1, VOTE_NONE: 2})
+	if err := e.Join("cid"); !errors.Is(err, ErrAlreadyJoined) {
+		t.Errorf("second Join() = %v, want ErrAlreadyJoined", err)
+	}
+	if err := e.Vote("cid", VOTE_NO); err != nil {
+		t.Fatalf("Vote() after Join() = %v", err)
+	}
+
+	// Leaving takes the vote along
+	if err := e.Leave("ann"); err != nil {
+		t.Fatalf("Leave() = %v", err)
+	}
+	checkCounts(t, e, [3]int{VOTE_NO: 1, VOTE_NONE: 1})
+	if err := e.Leave("ann"); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("second Leave() = %v, want ErrUnknownUser", err)
+	}
+
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	stats := e.Stats()
+	if _, ok := stats["ann"]; ok {
+		t.Errorf("Stats() still has ann after leaving: %v", stats)
+	}
+	if stats["bob"] != [3]int{VOTE_NONE: 1} || stats["cid"] != [3]int{VOTE_NO: 1} {
+		t.Errorf("Stats() = %v", stats)
+	}
+}
+
+func TestEngineAbort(t *testing.T) {
+	e := NewEngine([]string{"ann", "bob"}, map[string][3]int{"ann": {1, 2, 3}})
+	if err := e.Open(testNow, testNow.Add(time.Hour)); err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	if err := e.AttachPoll("poll", 1); err != nil {
+		t.Fatalf("AttachPoll() = %v", err)
+	}
+	if err := e.Vote("ann", VOTE_YES); err != nil {
+		t.Fatalf("Vote() = %v", err)
+	}
+
+	if err := e.Abort(); err != nil {
+		t.Fatalf("Abort() = %v", err)
+	}
+	if got := e.Phase(); got != PhaseIdle {
+		t.Fatalf("aborted game is %s, want idle", got)
+	}
+	if e.IsPoll("poll") {
+		t.Error("aborted round still takes answers to its poll")
+	}
+	stats := e.Stats()
+	if stats["ann"] != [3]int{1, 2, 3} || stats["bob"] != [3]int{} {
+		t.Errorf("Stats() after Abort() = %v, want them unchanged", stats)
+	}
+
+	// The next round starts as if the aborted one never happened
+	if err := e.Open(testNow, testNow.Add(time.Hour)); err != nil {
+		t.Fatalf("Open() after Abort() = %v", err)
+	}
+	checkCounts(t, e, [3]int{VOTE_NONE: 2})
+}
+
+func TestEngineOpenInThePast(t *testing.T) {
+	e := NewEngine([]string{"ann"}, nil)
+
+	for _, end := range []time.Time{testNow.Add(-time.Minute), testNow} {
+		err := e.Open(testNow, end)
+		if err == nil {
+			t.Errorf("Open() ending at %s = nil, want an error", end.Format("15:04"))
+		}
+		var terr *TransitionError
+		if errors.As(err, &terr) {
+			t.Errorf("Open() ending at %s = %v, want a time error", end.Format("15:04"), err)
+		}
+	}
+	if got := e.Phase(); got != PhaseIdle {
+		t.Errorf("game is %s after failed Open(), want idle", got)
+	}
+}
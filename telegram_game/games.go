@@ -0,0 +1,329 @@
+//go:build darwin || windows
+
+package telegram_game
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Directory with the users and schedule files of every chat
+const gamesDir = "games"
+
+// Users file of the single game played before every chat had its own
+const legacyUsersFile = "users"
+
+var (
+	ErrNoLegacyRoster = errors.New("there is no old roster to adopt")
+	ErrHasRoster      = errors.New("the chat has a roster already")
+)
+
+// Game is the game of one chat.
+type Game struct {
+	ChatID int64
+	*Engine
+
+	// Guards schedule, openTimer and reminders, the engine has its own lock
+	schedMu   sync.Mutex
+	schedule  Schedule
+	openTimer *time.Timer
+
+	// Reminders of the running round, nil once the quorum was reached
+	reminders *timerSet
+}
+
+// Schedule returns the schedule of the chat.
+func (g *Game) Schedule() Schedule {
+	g.schedMu.Lock()
+	defer g.schedMu.Unlock()
+	return g.schedule
+}
+
+// Games keeps the game of every chat the bot plays in.
+type Games struct {
+	dir   string
+	mu    sync.Mutex
+	games map[int64]*Game
+
+	// Posts the daily poll of a game, set by Start
+	post func(game *Game)
+
+	// Legacy users file waiting for an admin to adopt it, see Adopt
+	legacy string
+}
+
+// NewGames loads the game of every group chat that has files in dir. A
+// legacy users file becomes the roster of chatID, or waits for Adopt if
+// chatID is 0.
+func NewGames(dir, legacy string, chatID int64) (*Games, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create games dir: %w", err)
+	}
+	g := &Games{dir: dir, games: make(map[int64]*Game)}
+	if err := g.migrate(legacy, chatID); err != nil {
+		return nil, fmt.Errorf("migrate %s: %w", legacy, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read games dir: %w", err)
+	}
+	for _, entry := range entries {
+		name, _, _ := strings.Cut(entry.Name(), ".")
+		chatID, err := strconv.ParseInt(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		// Only groups have negative IDs, older versions played in any chat
+		if chatID >= 0 {
+			log.Printf("Skipping %s, chat %d is not a group", entry.Name(), chatID)
+			continue
+		}
+		if _, err := g.Get(chatID); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// migrate moves the legacy users file to the game of chatID, or keeps it
+// for an admin to adopt. A chat that has a roster keeps it, the legacy file
+// is left alone then.
+func (g *Games) migrate(legacy string, chatID int64) error {
+	if _, err := os.Stat(legacy); errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if chatID == 0 {
+		log.Printf("WARNING: %s is the roster of the old single game, "+
+			"an admin adopts it with /adopt in the chat that played. Or set game_chat to pick the chat", legacy)
+		g.legacy = legacy
+		return nil
+	}
+
+	path := g.path(chatID, "users")
+	if _, err := os.Stat(path); err == nil {
+		log.Printf("WARNING: %s is IGNORED, chat %d already has its roster in %s. "+
+			"Merge them by hand and remove %s", legacy, chatID, path, legacy)
+		return nil
+	}
+	log.Printf("Moving the roster %s to chat %d", legacy, chatID)
+	return os.Rename(legacy, path)
+}
+
+func (g *Games) path(chatID int64, kind string) string {
+	return filepath.Join(g.dir, fmt.Sprintf("%d.%s", chatID, kind))
+}
+
+// Get returns the game of a group chat, loading its roster, stats and
+// schedule the first time. A chat without files starts with an empty
+// roster. Callers only ask for the games of groups.
+func (g *Games) Get(chatID int64) (*Game, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if game, ok := g.games[chatID]; ok {
+		return game, nil
+	}
+	return g.loadLocked(chatID)
+}
+
+func (g *Games) loadLocked(chatID int64) (*Game, error) {
+	engine, err := LoadEngine(g.path(chatID, "users"))
+	if errors.Is(err, os.ErrNotExist) {
+		engine, err = NewEngine(nil, nil), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load game of chat %d: %w", chatID, err)
+	}
+	schedule, err := readSchedule(g.path(chatID, "schedule"))
+	if err != nil {
+		return nil, fmt.Errorf("load schedule of chat %d: %w", chatID, err)
+	}
+
+	game := &Game{ChatID: chatID, Engine: engine, schedule: schedule}
+	g.games[chatID] = game
+	g.arm(game, time.Now(), g.post)
+	return game, nil
+}
+
+// Adopt makes the legacy users file the roster of a game that has none
+// yet, and returns the game loaded from it. The game must be idle.
+func (g *Games) Adopt(game *Game) (*Game, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.legacy == "" {
+		return nil, ErrNoLegacyRoster
+	}
+	if game.Phase() != PhaseIdle {
+		return nil, &TransitionError{From: game.Phase(), Event: "adopt a roster"}
+	}
+	path := g.path(game.ChatID, "users")
+	if _, err := os.Stat(path); err == nil {
+		return nil, ErrHasRoster
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	log.Printf("Moving the roster %s to chat %d", g.legacy, game.ChatID)
+	if err := os.Rename(g.legacy, path); err != nil {
+		return nil, err
+	}
+	g.legacy = ""
+
+	disarm(game)
+	delete(g.games, game.ChatID)
+	return g.loadLocked(game.ChatID)
+}
+
+// Remove unloads the game of a chat the bot left and turns its daily poll
+// off, so it isn't posted there after a restart. The roster and stats are
+// kept for when the bot is added again.
+func (g *Games) Remove(chatID int64) error {
+	g.mu.Lock()
+	game, ok := g.games[chatID]
+	delete(g.games, chatID)
+	g.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	disarm(game)
+	// The poll of a running round is gone with the chat
+	_ = game.Abort()
+
+	game.schedMu.Lock()
+	game.schedule.Auto = false
+	schedule := game.schedule
+	game.schedMu.Unlock()
+
+	return errors.Join(g.Save(game), writeSchedule(g.path(chatID, "schedule"), schedule))
+}
+
+// ByPoll returns the game whose running round has the poll, or nil. Poll
+// answers don't say which chat they came from.
+func (g *Games) ByPoll(pollID string) *Game {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, game := range g.games {
+		if game.IsPoll(pollID) {
+			return game
+		}
+	}
+	return nil
+}
+
+// Save writes the roster and stats of a game.
+func (g *Games) Save(game *Game) error {
+	return game.WriteStats(g.path(game.ChatID, "users"))
+}
+
+// SaveAll writes every loaded game.
+func (g *Games) SaveAll() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var errs []error
+	for _, game := range g.games {
+		errs = append(errs, g.Save(game))
+	}
+	return errors.Join(errs...)
+}
+
+// SetSchedule stores the schedule of a game and plans its next poll.
+func (g *Games) SetSchedule(game *Game, schedule Schedule) error {
+	game.schedMu.Lock()
+	game.schedule = schedule
+	game.schedMu.Unlock()
+
+	g.arm(game, time.Now(), g.poster())
+	return writeSchedule(g.path(game.ChatID, "schedule"), schedule)
+}
+
+// Start posts the polls of every game with a daily schedule with post,
+// from now on until Stop.
+func (g *Games) Start(post func(game *Game)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.post = post
+	now := time.Now()
+	for _, game := range g.games {
+		g.arm(game, now, post)
+	}
+}
+
+func (g *Games) poster() func(game *Game) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.post
+}
+
+func (g *Games) loaded(game *Game) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.games[game.ChatID] == game
+}
+
+// Stop cancels every planned poll.
+func (g *Games) Stop() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.post = nil
+	for _, game := range g.games {
+		disarm(game)
+	}
+}
+
+// disarm cancels the planned poll of a game.
+func disarm(game *Game) {
+	game.schedMu.Lock()
+	defer game.schedMu.Unlock()
+
+	if game.openTimer != nil {
+		game.openTimer.Stop()
+		game.openTimer = nil
+	}
+}
+
+// arm plans the first poll of a game after t with post, replacing the one
+// planned before. The timer plans the following poll once it fired, from
+// the time it was due rather than the time it ran, so a timer that fires a
+// little early doesn't post twice.
+func (g *Games) arm(game *Game, t time.Time, post func(game *Game)) {
+	game.schedMu.Lock()
+	defer game.schedMu.Unlock()
+
+	if game.openTimer != nil {
+		game.openTimer.Stop()
+		game.openTimer = nil
+	}
+	if !game.schedule.Auto || post == nil {
+		return
+	}
+
+	loc, _ := MoscowTime()
+	at := game.schedule.NextOpen(t.In(loc))
+	log.Printf("Next poll of chat %d at %s", game.ChatID, at)
+
+	game.openTimer = time.AfterFunc(time.Until(at), func() {
+		// A game that was removed or adopted meanwhile has no poll to post
+		if !g.loaded(game) {
+			return
+		}
+		post(game)
+		g.arm(game, at, g.poster())
+	})
+}
@@ -0,0 +1,139 @@
+//go:build darwin || windows
+
+package telegram_game
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+
+	. "github.com/ws117z5/telegram_bot/functions"
+)
+
+// timerSet is a group of timers stopped together.
+type timerSet struct {
+	mu      sync.Mutex
+	timers  []*time.Timer
+	stopped bool
+}
+
+// at runs f at t unless the set is stopped first.
+func (s *timerSet) at(t time.Time, f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return
+	}
+	s.timers = append(s.timers, time.AfterFunc(time.Until(t), f))
+}
+
+func (s *timerSet) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stopped = true
+	for _, timer := range s.timers {
+		timer.Stop()
+	}
+	s.timers = nil
+}
+
+// planRound sets the timers of the running round of a game: one reminder
+// per offset of its schedule that is still ahead, and the deadline, which
+// closes the round. Planning again, after the deadline moved, replaces the
+// timers planned before. Closing the round stops them all.
+func (g *Games) planRound(bot *telego.Bot, game *Game) error {
+	schedule := game.Schedule()
+	endTime := game.EndTime()
+	_, now := MoscowTime()
+
+	// A quorum reached before planning again keeps the reminders off
+	var reminders *timerSet
+	if schedule.Quorum <= 0 || game.Counts()[VOTE_YES] < schedule.Quorum {
+		reminders = &timerSet{}
+		for _, offset := range schedule.Reminders {
+			if at := endTime.Add(offset); at.After(now) {
+				reminders.at(at, func() { game.remind(bot, offset) })
+			}
+		}
+	}
+
+	deadline := &timerSet{}
+	deadline.at(endTime, func() {
+		if err := game.Close(); err != nil {
+			return
+		}
+		game.PrintStats(context.Background(), bot, tu.ID(game.ChatID))
+		if err := g.Save(game); err != nil {
+			log.Printf("Save: %s", err)
+		}
+	})
+
+	game.schedMu.Lock()
+	game.reminders = reminders
+	game.schedMu.Unlock()
+
+	stop := func() {
+		if reminders != nil {
+			reminders.stop()
+		}
+		deadline.stop()
+	}
+	if err := game.observe(stop); err != nil {
+		stop()
+		return err
+	}
+	return nil
+}
+
+// remind mentions everyone who hasn't voted yet, except those who opted
+// out. Nothing is sent if that's nobody.
+func (g *Game) remind(bot *telego.Bot, offset time.Duration) {
+	users := g.getReminded()
+	if len(users) == 0 || g.Phase() != PhasePolling {
+		return
+	}
+
+	_, err := bot.SendMessage(context.Background(),
+		tu.Message(
+			tu.ID(g.ChatID),
+			strings.Join(Map(users, MapUsernames), " ")+"\n Осталось "+formatOffset(offset)[1:],
+		),
+	)
+	if err != nil {
+		log.Printf("Reminder of chat %d: %s", g.ChatID, err)
+	}
+}
+
+// CheckQuorum stops the reminders of the round once enough users voted yes
+// and tells the chat. It is called after every vote, the reminders are only
+// stopped once.
+func (g *Game) CheckQuorum(bot *telego.Bot) {
+	quorum := g.Schedule().Quorum
+	if quorum <= 0 || g.Counts()[VOTE_YES] < quorum {
+		return
+	}
+
+	g.schedMu.Lock()
+	reminders := g.reminders
+	g.reminders = nil
+	g.schedMu.Unlock()
+	if reminders == nil {
+		return
+	}
+	reminders.stop()
+
+	bot.SendMessage(context.Background(),
+		tu.Message(
+			tu.ID(g.ChatID),
+			fmt.Sprintf("Кворум есть: %d готовы играть", quorum),
+		),
+	)
+}
@@ -0,0 +1,214 @@
+package telegram_game
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/ws117z5/telegram_bot/functions"
+)
+
+// Clock is a time of day.
+type Clock struct {
+	Hour, Minute int
+}
+
+func ParseClock(s string) (Clock, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return Clock{}, fmt.Errorf("%q is not a time like 18:00", s)
+	}
+	return Clock{t.Hour(), t.Minute()}, nil
+}
+
+func (c Clock) String() string {
+	return fmt.Sprintf("%02d:%02d", c.Hour, c.Minute)
+}
+
+// on returns the clock on the day of t, in t's location. Going through
+// time.Date keeps the wall clock right on days with a DST change, a time
+// skipped by the change moves forward by the gap.
+func (c Clock) on(t time.Time, days int) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day+days, c.Hour, c.Minute, 0, 0, t.Location())
+}
+
+// next returns the first time after t the clock shows c.
+func (c Clock) next(t time.Time) time.Time {
+	if at := c.on(t, 0); at.After(t) {
+		return at
+	}
+	return c.on(t, 1)
+}
+
+// Schedule is when the polls of a chat open, when they end and when the
+// chat is reminded before the end.
+type Schedule struct {
+	// Polls are posted every day at Open when Auto is set, otherwise only
+	// on /start
+	Auto bool
+	Open Clock
+
+	End Clock
+
+	// Offsets from End, all negative
+	Reminders []time.Duration
+
+	// Yes votes after which nobody is reminded anymore, 0 for none
+	Quorum int
+}
+
+// Hour (Moscow time) polls end at unless the chat set another
+const defaultEndHour = 23
+
+func defaultSchedule() Schedule {
+	return Schedule{
+		End:       Clock{Hour: defaultEndHour},
+		Reminders: []time.Duration{-time.Hour},
+	}
+}
+
+// NextOpen returns when the next poll is posted after t.
+func (s Schedule) NextOpen(t time.Time) time.Time {
+	return s.Open.next(t)
+}
+
+// Deadline returns when a poll opened at t ends: the first End after it,
+// which is the next day for an end past midnight.
+func (s Schedule) Deadline(t time.Time) time.Time {
+	return s.End.next(t)
+}
+
+func (s Schedule) String() string {
+	var b strings.Builder
+	if s.Auto {
+		fmt.Fprintf(&b, "Poll every day at %s, ", s.Open)
+	} else {
+		b.WriteString("Poll on /start, ")
+	}
+	fmt.Fprintf(&b, "ends at %s", s.End)
+	if len(s.Reminders) > 0 {
+		b.WriteString(", reminders " + strings.Join(Map(s.Reminders, formatOffset), " "))
+	}
+	if s.Quorum > 0 {
+		fmt.Fprintf(&b, " until %d say yes", s.Quorum)
+	}
+	return b.String()
+}
+
+// formatOffset writes a reminder offset the way ParseSchedule reads it.
+func formatOffset(d time.Duration) string {
+	s := "-"
+	if h := -d / time.Hour; h > 0 {
+		s += fmt.Sprintf("%dh", h)
+	}
+	if m := -d % time.Hour / time.Minute; m > 0 {
+		s += fmt.Sprintf("%dm", m)
+	}
+	return s
+}
+
+// ParseSchedule reads the arguments of /schedule: "18:00 23:00 -1h -15m"
+// posts a poll daily at 18:00 ending at 23:00 with two reminders.
+func ParseSchedule(args []string) (Schedule, error) {
+	if len(args) < 2 {
+		return Schedule{}, errors.New("usage: /schedule 18:00 23:00 [-1h -15m ...]")
+	}
+
+	open, err := ParseClock(args[0])
+	if err != nil {
+		return Schedule{}, err
+	}
+	end, err := ParseClock(args[1])
+	if err != nil {
+		return Schedule{}, err
+	}
+	if open == end {
+		return Schedule{}, errors.New("the poll can't open and end at the same time")
+	}
+
+	reminders, err := parseReminders(args[2:])
+	if err != nil {
+		return Schedule{}, err
+	}
+	return Schedule{Auto: true, Open: open, End: end, Reminders: reminders}, nil
+}
+
+func parseReminders(args []string) ([]time.Duration, error) {
+	reminders := []time.Duration{}
+	for _, arg := range args {
+		d, err := time.ParseDuration(arg)
+		if err != nil || d >= 0 || d <= -24*time.Hour || d%time.Minute != 0 {
+			return nil, fmt.Errorf("%q is not a reminder like -1h or -15m", arg)
+		}
+		reminders = append(reminders, d)
+	}
+	return reminders, nil
+}
+
+// The schedule file has one "key values..." line per field:
+//
+//	open 18:00
+//	end 23:00
+//	remind -1h -15m
+//	quorum 4
+//
+// A chat without the open line posts polls only on /start.
+
+func readSchedule(path string) (Schedule, error) {
+	s := defaultSchedule()
+	lines, err := ReadLines(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "open":
+			if len(fields) != 2 {
+				return s, fmt.Errorf("invalid line %q", line)
+			}
+			s.Auto = true
+			s.Open, err = ParseClock(fields[1])
+		case "end":
+			if len(fields) != 2 {
+				return s, fmt.Errorf("invalid line %q", line)
+			}
+			s.End, err = ParseClock(fields[1])
+		case "remind":
+			s.Reminders, err = parseReminders(fields[1:])
+		case "quorum":
+			if len(fields) != 2 {
+				return s, fmt.Errorf("invalid line %q", line)
+			}
+			s.Quorum, err = strconv.Atoi(fields[1])
+		}
+		if err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+func writeSchedule(path string, s Schedule) error {
+	lines := []string{}
+	if s.Auto {
+		lines = append(lines, "open "+s.Open.String())
+	}
+	lines = append(lines, "end "+s.End.String())
+	lines = append(lines, strings.Join(append([]string{"remind"}, Map(s.Reminders, formatOffset)...), " "))
+	if s.Quorum > 0 {
+		lines = append(lines, fmt.Sprintf("quorum %d", s.Quorum))
+	}
+	return WriteLines(lines, path)
+}
@@ -0,0 +1,137 @@
+package telegram_game
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func sameSchedule(a, b Schedule) bool {
+	return a.Auto == b.Auto && a.Open == b.Open && a.End == b.End &&
+		slices.Equal(a.Reminders, b.Reminders) && a.Quorum == b.Quorum
+}
+
+func TestParseSchedule(t *testing.T) {
+	got, err := ParseSchedule(strings.Fields("18:00 23:30 -1h -15m -1h30m"))
+	if err != nil {
+		t.Fatalf("ParseSchedule() = %v", err)
+	}
+	want := Schedule{
+		Auto:      true,
+		Open:      Clock{18, 0},
+		End:       Clock{23, 30},
+		Reminders: []time.Duration{-time.Hour, -15 * time.Minute, -90 * time.Minute},
+	}
+	if !sameSchedule(got, want) {
+		t.Errorf("ParseSchedule() = %+v, want %+v", got, want)
+	}
+
+	for _, args := range []string{
+		"",
+		"18:00",
+		"6pm 23:00",
+		"18:00 24:00",
+		"18:00 18:00",
+		"18:00 23:00 1h",
+		"18:00 23:00 -24h",
+		"18:00 23:00 -90s",
+		"18:00 23:00 soon",
+	} {
+		if s, err := ParseSchedule(strings.Fields(args)); err == nil {
+			t.Errorf("ParseSchedule(%q) = %+v, want an error", args, s)
+		}
+	}
+}
+
+func TestScheduleFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedule")
+
+	s, err := readSchedule(path)
+	if err != nil {
+		t.Fatalf("readSchedule() of a missing file = %v", err)
+	}
+	if !sameSchedule(s, defaultSchedule()) {
+		t.Errorf("readSchedule() of a missing file = %+v, want the default", s)
+	}
+
+	for _, want := range []Schedule{
+		defaultSchedule(),
+		{Auto: true, Open: Clock{18, 0}, End: Clock{1, 15}, Reminders: []time.Duration{-time.Hour, -10 * time.Minute}, Quorum: 4},
+		{End: Clock{22, 0}, Reminders: []time.Duration{}},
+	} {
+		if err := writeSchedule(path, want); err != nil {
+			t.Fatalf("writeSchedule() = %v", err)
+		}
+		got, err := readSchedule(path)
+		if err != nil {
+			t.Fatalf("readSchedule() = %v", err)
+		}
+		if !sameSchedule(got, want) {
+			t.Errorf("schedule read back as %+v, want %+v", got, want)
+		}
+	}
+}
+
+func TestClockNextAcrossDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		clock Clock
+		from  time.Time
+		want  time.Time
+	}{
+		{
+			name:  "later today",
+			clock: Clock{18, 0},
+			from:  time.Date(2024, 3, 30, 12, 0, 0, 0, berlin),
+			want:  time.Date(2024, 3, 30, 18, 0, 0, 0, berlin),
+		},
+		{
+			// The day the clocks go forward has 23 hours
+			name:  "into summer time",
+			clock: Clock{18, 0},
+			from:  time.Date(2024, 3, 30, 20, 0, 0, 0, berlin),
+			want:  time.Date(2024, 3, 31, 18, 0, 0, 0, berlin),
+		},
+		{
+			// The day the clocks go back has 25 hours
+			name:  "into winter time",
+			clock: Clock{18, 0},
+			from:  time.Date(2024, 10, 26, 18, 0, 0, 0, berlin),
+			want:  time.Date(2024, 10, 27, 18, 0, 0, 0, berlin),
+		},
+		{
+			// 02:30 doesn't exist on that day
+			name:  "skipped time",
+			clock: Clock{2, 30},
+			from:  time.Date(2024, 3, 30, 12, 0, 0, 0, berlin),
+			want:  time.Date(2024, 3, 31, 3, 30, 0, 0, berlin),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.clock.next(tt.from)
+			if !got.Equal(tt.want) {
+				t.Errorf("next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+
+	// Wall clock days, not 24 hour ones
+	spring := Clock{18, 0}.next(time.Date(2024, 3, 30, 18, 0, 0, 0, berlin))
+	if d := spring.Sub(time.Date(2024, 3, 30, 18, 0, 0, 0, berlin)); d != 23*time.Hour {
+		t.Errorf("18:00 to 18:00 into summer time is %s, want 23h", d)
+	}
+	autumn := Clock{18, 0}.next(time.Date(2024, 10, 26, 18, 0, 0, 0, berlin))
+	if d := autumn.Sub(time.Date(2024, 10, 26, 18, 0, 0, 0, berlin)); d != 25*time.Hour {
+		t.Errorf("18:00 to 18:00 into winter time is %s, want 25h", d)
+	}
+}
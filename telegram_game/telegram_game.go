@@ -3,9 +3,9 @@
 package telegram_game
 
 import (
-	"bufio"
 	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"maps"
@@ -22,198 +22,6 @@ import (
 	. "github.com/ws117z5/telegram_bot/functions"
 )
 
-const (
-	VOTE_YES = iota
-	VOTE_NO
-	VOTE_NONE
-)
-
-type State struct {
-	active  bool
-	started bool
-
-	messageId int
-	users     []string
-	votes     map[string]byte
-	voteCount []int
-
-	user_statistics map[string][3]int
-
-	endTime              time.Time
-	startTime            time.Time
-	cancelSubroutineFunc context.CancelFunc
-}
-
-func NewState() *State {
-	s := new(State)
-	s.voteCount = make([]int, 3)
-	s.votes = make(map[string]byte)
-	s.user_statistics = make(map[string][3]int)
-
-	usersCount := len(s.users)
-
-	lines, err := ReadLines("users")
-	if err != nil {
-		log.Fatalf("readLines: %s", err)
-	}
-
-	//update user stats
-	for _, line := range lines {
-		data := strings.Split(line, " ")
-		if len(data) == 4 {
-			name := data[0]
-			s.users = append(s.users, name)
-			s.user_statistics[name] = [3]int{0, 0, 0}
-
-			//worst mechanics ever thanks golang
-			var tmp = s.user_statistics[name]
-
-			var j int
-			for i := 1; i <= 3; i++ {
-				_, err := fmt.Sscan(data[i], &j)
-				if err == nil {
-					tmp[i-1] = j
-				}
-			}
-
-			s.user_statistics[name] = tmp
-		}
-
-	}
-
-	_, s.endTime = MoscowTime(23)
-
-	fmt.Println("End Time:", s.endTime)
-
-	s.users = Map(lines, MapUsernames)
-
-	for _, u := range s.users {
-		s.votes[u] = VOTE_NONE
-	}
-
-	s.voteCount[VOTE_NONE] = usersCount
-	s.voteCount[VOTE_NO] = 0
-	s.voteCount[VOTE_YES] = 0
-
-	s.started = true
-	s.active = false
-
-	return s
-}
-
-func (s State) WriteStats() error {
-
-	file, err := os.Open("users")
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	w := bufio.NewWriter(file)
-
-	for _, user := range s.users {
-		stats := s.user_statistics[user]
-		fmt.Fprintf(w, "%s %d %d %d", user, stats[0], stats[1], stats[2])
-	}
-	return w.Flush()
-}
-
-func (s State) setUserVote(username string, option int) {
-	tmp := s.user_statistics[username]
-	if option == VOTE_YES {
-		s.votes[username] = VOTE_YES
-		s.voteCount[VOTE_NONE]--
-		s.voteCount[VOTE_YES]++
-
-		tmp[VOTE_YES]++
-		tmp[VOTE_NONE]--
-		//state.user_statistics[username][VOTE_YES]++
-	} else if option == VOTE_NO {
-		s.votes[username] = VOTE_NO
-		s.voteCount[VOTE_NONE]--
-		s.voteCount[VOTE_NO]++
-
-		tmp[VOTE_NO]++
-		tmp[VOTE_NONE]--
-	} else {
-		//if vote was withdrawn
-		current_vote := s.votes[username]
-		if current_vote == VOTE_YES {
-			tmp[VOTE_YES]--
-			s.voteCount[VOTE_YES]--
-		} else {
-			tmp[VOTE_NO]--
-			s.voteCount[VOTE_NO]--
-		}
-
-		s.votes[username] = VOTE_NONE
-		s.voteCount[VOTE_NONE]++
-		tmp[VOTE_NONE]++
-	}
-
-	s.user_statistics[username] = tmp
-}
-
-func (s State) reset() {
-	for _, u := range s.users {
-		s.votes[u] = VOTE_NONE
-	}
-
-	usersCount := len(s.users)
-
-	s.voteCount[VOTE_NONE] = usersCount
-	s.voteCount[VOTE_NO] = 0
-	s.voteCount[VOTE_YES] = 0
-}
-
-func (s State) Init(message *telego.Message) {
-	s.reset()
-	s.active = true
-	s.messageId = message.MessageID
-	_, s.startTime = MoscowTime()
-}
-
-func (s State) TimeFromStart(t time.Time) int {
-
-	_, currentTime := MoscowTime()
-	diff := s.startTime.Sub(currentTime)
-
-	return int(diff.Seconds())
-}
-
-func (s State) getIgnored() []string {
-	ret := []string{}
-	for _, u := range s.users {
-		if s.votes[u] == VOTE_NONE {
-			ret = append(ret, u)
-		}
-	}
-
-	return ret
-}
-
-func (s State) getVotedYes() []string {
-	ret := []string{}
-	for _, u := range s.users {
-		if s.votes[u] == VOTE_YES {
-			ret = append(ret, u)
-		}
-	}
-
-	return ret
-}
-
-func (s State) getVotedNo() []string {
-	ret := []string{}
-	for _, u := range s.users {
-		if s.votes[u] == VOTE_NO {
-			ret = append(ret, u)
-		}
-	}
-
-	return ret
-}
-
 // Not sure about this args
 func MoscowTime(args ...int) (*time.Location, time.Time) {
 	//init the loc
@@ -229,40 +37,6 @@ func MoscowTime(args ...int) (*time.Location, time.Time) {
 	return loc, time.Now().In(loc)
 }
 
-func (s State) LaunchTimeObserver(bot *telego.Bot, chatID telego.ChatID) {
-	ctx, cancel := context.WithCancel(context.Background())
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				_, now := MoscowTime()
-				hourBeforeEnd := s.endTime.Add(-1 * time.Hour)
-
-				//diff := now.Sub(s.endTime)
-
-				if hourBeforeEnd.Before(now) {
-					bot.SendMessage(ctx,
-						tu.Message(
-							chatID,
-							strings.Join(s.users, " ")+"\n Остался час",
-						),
-					)
-				}
-
-				if s.endTime.Before(now) {
-					return
-				}
-			}
-
-			time.Sleep(time.Second * 5)
-		}
-	}()
-
-	s.cancelSubroutineFunc = cancel
-}
-
 type UserStats struct {
 	name string
 	yes  int
@@ -270,9 +44,10 @@ type UserStats struct {
 	none int
 }
 
-func (s State) PrintStats(ctx context.Context, bot *telego.Bot, chatID telego.ChatID) {
+func (e *Engine) PrintStats(ctx context.Context, bot *telego.Bot, chatID telego.ChatID) {
 
-	user_statisticsIdx := slices.Collect(maps.Values(s.user_statistics))
+	user_statisticsIdx := slices.Collect(maps.Values(e.Stats()))
+	voteCount := e.Counts()
 
 	lenCmp := func(a, b [3]int) int {
 		return cmp.Or(
@@ -287,9 +62,9 @@ func (s State) PrintStats(ctx context.Context, bot *telego.Bot, chatID telego.Ch
 	bot.SendMessage(ctx,
 		tu.Message(
 			chatID,
-			"Готовы играть: "+fmt.Sprintf("%v", s.voteCount[VOTE_YES])+"\n"+
-				"Геи: "+fmt.Sprintf("%v", s.voteCount[VOTE_NO])+"\n"+
-				"Курят бамбук: "+fmt.Sprintf("%v", s.voteCount[VOTE_NONE]),
+			"Готовы играть: "+fmt.Sprintf("%v", voteCount[VOTE_YES])+"\n"+
+				"Геи: "+fmt.Sprintf("%v", voteCount[VOTE_NO])+"\n"+
+				"Курят бамбук: "+fmt.Sprintf("%v", voteCount[VOTE_NONE]),
 		),
 	)
 	fmt.Println(user_statisticsIdx)
@@ -308,9 +83,17 @@ func runBot() {
 		os.Exit(1)
 	}
 
-	state := NewState()
+	games, err := NewGames(gamesDir, legacyUsersFile, cfg.GameChat)
+	if err != nil {
+		log.Fatalf("NewGames: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	ctx, _ := context.WithCancel(context.Background())
+	// Post the daily polls of chats with a schedule
+	games.Start(func(game *Game) { startPoll(ctx, bot, games, game) })
+	defer games.Stop()
 
 	// Get updates channel
 	updates, _ := bot.UpdatesViaLongPolling(ctx, nil)
@@ -323,87 +106,338 @@ func runBot() {
 	// Loop through all updates when they came
 	for update := range updates {
 
-		//If we have an active vote we want to register it inside state
-		if state.active && update.PollAnswer != nil {
-			username := update.PollAnswer.User.Username
+		//Poll answers carry no chat, find the game by its poll
+		if answer := update.PollAnswer; answer != nil && answer.User != nil {
+			if game := games.ByPoll(answer.PollID); game != nil {
+				//An empty answer means the vote was withdrawn
+				option := VOTE_NONE
+				if len(answer.OptionIDs) > 0 {
+					option = answer.OptionIDs[0]
+				}
 
-			//Update the vote count
-			state.setUserVote(username, update.PollAnswer.OptionIDs[0])
+				if err := game.Vote(answer.User.Username, option); err != nil {
+					log.Printf("Vote of %s in %d: %s", answer.User.Username, game.ChatID, err)
+				}
+				game.CheckQuorum(bot)
+			}
+		}
+
+		//Forget the game of a group the bot was removed from
+		if member := update.MyChatMember; member != nil && isGroup(member.Chat) {
+			status := member.NewChatMember.MemberStatus()
+			if status == telego.MemberStatusLeft || status == telego.MemberStatusBanned {
+				if err := games.Remove(member.Chat.ID); err != nil {
+					log.Printf("Remove %d: %s", member.Chat.ID, err)
+				}
+			}
 		}
 
 		// Check if update contains a message
-		if update.Message != nil {
+		if update.Message != nil && update.Message.From != nil {
 
 			// Get chat ID and username from the message
 			chatID := tu.ID(update.Message.Chat.ID)
 			username := update.Message.From.Username
 
+			//The game is only played in groups
+			if !isGroup(update.Message.Chat) {
+				if strings.HasPrefix(update.Message.Text, "/") {
+					bot.SendMessage(ctx, tu.Message(chatID, "The game is played in groups, add me to one"))
+				}
+				continue
+			}
+
+			game, err := games.Get(update.Message.Chat.ID)
+			if err != nil {
+				log.Printf("Games.Get: %s", err)
+				continue
+			}
+
 			messageParams := strings.Split(update.Message.Text, " ")
+			isAdmin := func() bool {
+				return chatAdmin(ctx, bot, update.Message.Chat.ID, update.Message.From, cfg.TelegramBotAdmin)
+			}
 
 			if messageParams[0] == "/help" {
 
+			}
+			if messageParams[0] == "/adopt" && isAdmin() {
+				text := "The roster of the old game is the roster of this chat now"
+				if adopted, err := games.Adopt(game); err != nil {
+					text = err.Error()
+				} else {
+					game = adopted
+				}
+				bot.SendMessage(ctx, tu.Message(chatID, text))
 			}
 			//show stats
 			if messageParams[0] == "/stats" {
-				state.PrintStats(ctx, bot, chatID)
+				game.PrintStats(ctx, bot, chatID)
 			}
 
-			if state.active {
+			if messageParams[0] == "/join" || messageParams[0] == "/leave" {
+				bot.SendMessage(ctx, tu.Message(chatID, updateRoster(games, game, username, messageParams[0] == "/join")))
+			}
+
+			if game.Phase() == PhasePolling {
 				for _, word := range messageParams {
 					if word == "+" {
-						state.setUserVote(username, VOTE_YES)
+						_ = game.Vote(username, VOTE_YES)
 					}
 
 					if word == "-" {
-						state.setUserVote(username, VOTE_NO)
+						_ = game.Vote(username, VOTE_NO)
 					}
 				}
+				game.CheckQuorum(bot)
 			}
 
-			if messageParams[0] == "/setendtime" && username == cfg.TelegramBotAdmin {
+			if messageParams[0] == "/reminders" {
+				bot.SendMessage(ctx, tu.Message(chatID, setQuiet(games, game, username, messageParams[1:])))
+			}
 
+			if messageParams[0] == "/setendtime" && isAdmin() {
+				bot.SendMessage(ctx, tu.Message(chatID, setEndTime(bot, games, game, messageParams[1:])))
 			}
 
-			if messageParams[0] == "/start" && username == cfg.TelegramBotAdmin {
-
-				//Init state variables
-				state.Init(update.Message)
-
-				//Mention everyone in the first message
-				bot.SendMessage(ctx,
-					tu.Message(
-						chatID,
-						strings.Join(state.users, " "),
-					),
-				)
-
-				//Post a poll for gaming
-				bot.SendPoll(ctx,
-					&telego.SendPollParams{
-						ChatID:      chatID,
-						Question:    "Сыграем?",
-						Options:     []telego.InputPollOption{tu.PollOption("Да"), tu.PollOption("Нет, Я ")},
-						IsAnonymous: &[]bool{false}[0],
-					},
-				)
+			if messageParams[0] == "/quorum" && isAdmin() {
+				bot.SendMessage(ctx, tu.Message(chatID, setQuorum(games, game, messageParams[1:])))
 			}
 
-			if messageParams[0] == "/stop" && username == cfg.TelegramBotAdmin {
+			if messageParams[0] == "/schedule" {
+				text := game.Schedule().String()
+				if len(messageParams) > 1 && isAdmin() {
+					text = setSchedule(games, game, messageParams[1:])
+				}
+				bot.SendMessage(ctx, tu.Message(chatID, text))
+			}
+
+			if messageParams[0] == "/start" && isAdmin() {
+				startPoll(ctx, bot, games, game)
+			}
 
-				//Init state variables
-				state.reset()
-				state.active = false
-				state.messageId = 0
+			if messageParams[0] == "/stop" && isAdmin() {
+				if err := game.Close(); err != nil {
+					bot.SendMessage(ctx, tu.Message(chatID, err.Error()))
+					continue
+				}
+				game.PrintStats(ctx, bot, chatID)
+				if err := games.Save(game); err != nil {
+					log.Printf("Save: %s", err)
+				}
 			}
 		}
 	}
 
-	defer Exit(*state)
+	Exit(games)
+}
+
+func isGroup(chat telego.Chat) bool {
+	return chat.Type == telego.ChatTypeGroup || chat.Type == telego.ChatTypeSupergroup
+}
+
+// chatAdmin reports whether user may run the admin commands in a chat: the
+// bot admin anywhere, and the administrators of the chat in it.
+func chatAdmin(ctx context.Context, bot *telego.Bot, chatID int64, user *telego.User, botAdmin string) bool {
+	if user.Username != "" && user.Username == botAdmin {
+		return true
+	}
+
+	member, err := bot.GetChatMember(ctx, &telego.GetChatMemberParams{ChatID: tu.ID(chatID), UserID: user.ID})
+	if err != nil {
+		log.Printf("GetChatMember: %s", err)
+		return false
+	}
+	status := member.MemberStatus()
+	return status == telego.MemberStatusCreator || status == telego.MemberStatusAdministrator
+}
+
+// updateRoster adds or removes username from the game and returns the reply.
+func updateRoster(games *Games, game *Game, username string, join bool) string {
+	if username == "" {
+		return "Set a username in Telegram first, the game mentions players by it"
+	}
+
+	var err error
+	if join {
+		err = game.Join(username)
+	} else {
+		err = game.Leave(username)
+	}
+	switch {
+	case errors.Is(err, ErrAlreadyJoined):
+		return "You are already in the game"
+	case errors.Is(err, ErrUnknownUser):
+		return "You are not in the game"
+	case err != nil:
+		return err.Error()
+	}
+
+	if err := games.Save(game); err != nil {
+		log.Printf("Save: %s", err)
+	}
+	if join {
+		return MapUsernames(username) + " joined the game"
+	}
+	return MapUsernames(username) + " left the game"
+}
+
+// setEndTime handles "/setendtime 23:30": the polls of the chat end then
+// from now on, the running one included. It returns the reply.
+func setEndTime(bot *telego.Bot, games *Games, game *Game, args []string) string {
+	if len(args) != 1 {
+		return "Usage: /setendtime 23:30"
+	}
+	end, err := ParseClock(args[0])
+	if err != nil {
+		return err.Error()
+	}
+
+	schedule := game.Schedule()
+	schedule.End = end
+	if schedule.Auto && schedule.Open == end {
+		return "The poll can't open and end at the same time"
+	}
+	if err := games.SetSchedule(game, schedule); err != nil {
+		log.Printf("SetSchedule: %s", err)
+	}
+
+	if game.Phase() != PhasePolling {
+		return "Polls end at " + end.String()
+	}
+	_, now := MoscowTime()
+	endTime := schedule.Deadline(game.StartTime())
+	if err := game.SetEndTime(now, endTime); err != nil {
+		return "Polls end at " + end.String() + " from the next one, the current one can't: " + err.Error()
+	}
+	if err := games.planRound(bot, game); err != nil {
+		log.Printf("planRound: %s", err)
+	}
+	return "The poll ends at " + endTime.Format("15:04 02.01")
+}
+
+// setSchedule handles "/schedule 18:00 23:00 -1h -15m" and "/schedule off".
+// It returns the reply.
+func setSchedule(games *Games, game *Game, args []string) string {
+	var schedule Schedule
+	if args[0] == "off" {
+		schedule = game.Schedule()
+		schedule.Auto = false
+	} else {
+		var err error
+		if schedule, err = ParseSchedule(args); err != nil {
+			return err.Error()
+		}
+		schedule.Quorum = game.Schedule().Quorum
+	}
+
+	if err := games.SetSchedule(game, schedule); err != nil {
+		log.Printf("SetSchedule: %s", err)
+	}
+	if !schedule.Auto {
+		return schedule.String()
+	}
+	_, now := MoscowTime()
+	return schedule.String() + "\nNext poll: " + schedule.NextOpen(now).Format("15:04 02.01")
+}
+
+// setQuorum handles "/quorum 4": reminders stop once 4 users said yes, 0
+// turns that off. It returns the reply.
+func setQuorum(games *Games, game *Game, args []string) string {
+	var quorum int
+	if len(args) != 1 {
+		return "Usage: /quorum 4"
+	}
+	if _, err := fmt.Sscan(args[0], &quorum); err != nil || quorum < 0 {
+		return "Usage: /quorum 4"
+	}
+
+	schedule := game.Schedule()
+	schedule.Quorum = quorum
+	if err := games.SetSchedule(game, schedule); err != nil {
+		log.Printf("SetSchedule: %s", err)
+	}
+	return schedule.String()
+}
+
+// setQuiet handles "/reminders off" and "/reminders on" of a user. It
+// returns the reply.
+func setQuiet(games *Games, game *Game, username string, args []string) string {
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		return "Usage: /reminders on|off"
+	}
+
+	err := game.SetQuiet(username, args[0] == "off")
+	if errors.Is(err, ErrUnknownUser) {
+		return "You are not in the game, /join first"
+	}
+	if err != nil {
+		return err.Error()
+	}
+
+	if err := games.Save(game); err != nil {
+		log.Printf("Save: %s", err)
+	}
+	if args[0] == "off" {
+		return MapUsernames(username) + " won't be reminded to vote"
+	}
+	return MapUsernames(username) + " will be reminded to vote"
+}
+
+// startPoll opens a round of the chat's game and posts its poll.
+func startPoll(ctx context.Context, bot *telego.Bot, games *Games, game *Game) {
+	chatID := tu.ID(game.ChatID)
+	schedule := game.Schedule()
+	_, now := MoscowTime()
+	endTime := schedule.Deadline(now)
+
+	if err := game.Open(now, endTime); err != nil {
+		bot.SendMessage(ctx, tu.Message(chatID, err.Error()))
+		return
+	}
+
+	//Mention everyone in the first message
+	bot.SendMessage(ctx,
+		tu.Message(
+			chatID,
+			strings.Join(Map(game.Users(), MapUsernames), " "),
+		),
+	)
+
+	//Post a poll for gaming
+	poll, err := bot.SendPoll(ctx,
+		&telego.SendPollParams{
+			ChatID:      chatID,
+			Question:    "Сыграем?",
+			Options:     []telego.InputPollOption{tu.PollOption("Да"), tu.PollOption("Нет, Я ")},
+			IsAnonymous: &[]bool{false}[0],
+		},
+	)
+	if err == nil && poll.Poll == nil {
+		err = errors.New("the message has no poll")
+	}
+	if err != nil {
+		log.Printf("SendPoll: %s", err)
+		// Nobody could vote, so the round isn't counted
+		if err := game.Abort(); err != nil {
+			log.Printf("Abort: %s", err)
+		}
+		bot.SendMessage(ctx, tu.Message(chatID, "Couldn't post the poll: "+err.Error()))
+		return
+	}
+
+	if err := game.AttachPoll(poll.Poll.ID, poll.MessageID); err != nil {
+		log.Printf("AttachPoll: %s", err)
+		return
+	}
+	if err := games.planRound(bot, game); err != nil {
+		log.Printf("planRound: %s", err)
+	}
 }
 
-func Exit(s State) {
-	if s.active {
-		s.WriteStats()
+func Exit(games *Games) {
+	if err := games.SaveAll(); err != nil {
+		log.Printf("SaveAll: %s", err)
 	}
 	fmt.Println("Exiting")
 	os.Exit(0)
@@ -0,0 +1,104 @@
+package telegramstickers
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"strings"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// cloneSetName returns the set to clone, either from the command argument
+// or from the sticker the command replies to.
+func cloneSetName(message telego.Message) string {
+	_, _, args := tu.ParseCommand(message.Text)
+	if len(args) > 0 {
+		return strings.TrimPrefix(args[0], "https://t.me/addstickers/")
+	}
+
+	if reply := message.ReplyToMessage; reply != nil && reply.Sticker != nil {
+		return reply.Sticker.SetName
+	}
+	return ""
+}
+
+// handleClone copies every sticker of a set into the session so it can be
+// edited and created as a new pack.
+func (b *Bot) handleClone(ctx context.Context, message telego.Message) {
+	key, session := b.sessionFor(message)
+	if !b.ownerOnly(ctx, message.Chat.ID, session, message.From.ID) || b.jobPending(ctx, message.Chat.ID, session) {
+		return
+	}
+
+	setName := cloneSetName(message)
+	if setName == "" {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"Usage: /clone <set_name>\n\nOr reply /clone to any sticker from the set.",
+			))
+		return
+	}
+
+	set, err := b.api.GetStickerSet(ctx, &telego.GetStickerSetParams{Name: setName})
+	if err != nil {
+		log.Printf("Error getting sticker set %s: %v", setName, err)
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				fmt.Sprintf("Pack <code>%s</code> not found.", html.EscapeString(setName)),
+			).WithParseMode("HTML"))
+		return
+	}
+
+	if err := session.acceptType(set.StickerType); err != nil {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				fmt.Sprintf("Can't clone %s: %v", set.Title, err),
+			))
+		return
+	}
+
+	skipped, full := 0, 0
+	for i, sticker := range set.Stickers {
+		if !b.hasRoom(session) {
+			full = len(set.Stickers) - i
+			break
+		}
+		source := StickerSource{UniqueID: sticker.FileUniqueID}
+		if session.duplicateOf(source) > 0 {
+			skipped++
+			continue
+		}
+		session.appendSticker(inputFromSticker(sticker), source.by(message.From))
+	}
+	if session.PackTitle == "" {
+		session.PackTitle = set.Title
+	}
+	session.awaitingEmoji = 0
+	b.saveSession(key, session)
+
+	text := fmt.Sprintf("Copied %d sticker(s) from <b>%s</b>. Total: %d\n\n",
+		len(set.Stickers)-skipped-full, html.EscapeString(set.Title), len(session.Stickers))
+	if skipped > 0 {
+		text += fmt.Sprintf("%d sticker(s) were already in your session and were skipped.\n\n", skipped)
+	}
+	if full > 0 {
+		text += fmt.Sprintf("%d sticker(s) didn't fit, a session holds at most %d stickers.\n\n", full, b.limits.MaxStickers)
+	}
+	text += "Use /list to edit them, /title and /name to name your copy, then /create."
+
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(message.Chat.ID),
+			text,
+		).WithParseMode("HTML"))
+}
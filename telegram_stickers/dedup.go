@@ -0,0 +1,209 @@
+package telegramstickers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"math/bits"
+	"strings"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+	"golang.org/x/image/draw"
+)
+
+// Images whose hashes differ in at most this many bits are considered the
+// same picture
+const maxHashDistance = 6
+
+// StickerSource identifies where a session sticker came from, so the same
+// sticker or picture isn't added twice.
+type StickerSource struct {
+	// FileUniqueID of a sticker sent as is
+	UniqueID string `json:"unique_id,omitempty"`
+
+	// Perceptual hash of a converted image, 0 if there is none
+	Hash uint64 `json:"hash,omitempty"`
+
+	// SHA-256 of the file the sticker was made from, "" if unknown
+	Digest string `json:"digest,omitempty"`
+
+	// Who sent the sticker, kept to credit group members
+	AddedBy     int64  `json:"added_by,omitempty"`
+	AddedByName string `json:"added_by_name,omitempty"`
+}
+
+// by returns the source credited to user.
+func (s StickerSource) by(user *telego.User) StickerSource {
+	s.AddedBy = user.ID
+	s.AddedByName = displayName(user)
+	return s
+}
+
+// same reports whether two sources are the same sticker or file.
+func (s StickerSource) same(other StickerSource) bool {
+	if s.UniqueID != "" && s.UniqueID == other.UniqueID {
+		return true
+	}
+	return s.Digest != "" && s.Digest == other.Digest
+}
+
+// looksLike reports whether two sources are close enough to be the same
+// picture. Different pictures can hash close too, so it's only a warning.
+func (s StickerSource) looksLike(other StickerSource) bool {
+	return s.Hash != 0 && other.Hash != 0 && bits.OnesCount64(s.Hash^other.Hash) <= maxHashDistance
+}
+
+// contentDigest identifies a file by its bytes, for formats that can't be
+// hashed perceptually.
+func contentDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// imageHash computes a difference hash: the image is shrunk to 9x8 gray
+// pixels and every bit tells whether a pixel is brighter than its right
+// neighbour. Rescaled or recompressed copies get the same or a close hash.
+func imageHash(data []byte) (uint64, error) {
+	src, err := decodeImage(data)
+	if err != nil {
+		return 0, err
+	}
+
+	gray := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.ApproxBiLinear.Scale(gray, gray.Bounds(), src, src.Bounds(), draw.Src, nil)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if gray.GrayAt(x, y).Y > gray.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	// A flat image hashes to 0 and is left unhashed, so plain colors don't
+	// all match each other
+	return hash, nil
+}
+
+// alignSources makes Sources as long as Stickers, sessions saved before
+// sources were tracked have none.
+func (s *UserSession) alignSources() {
+	for len(s.Sources) < len(s.Stickers) {
+		s.Sources = append(s.Sources, StickerSource{})
+	}
+	s.Sources = s.Sources[:len(s.Stickers)]
+}
+
+// appendSticker adds a sticker to the end of the session.
+func (s *UserSession) appendSticker(sticker telego.InputSticker, source StickerSource) {
+	s.do(editStep{op: stepAdd, index: len(s.Stickers), sticker: sticker, source: source})
+}
+
+// removeSticker removes the sticker at index i.
+func (s *UserSession) removeSticker(i int) {
+	s.alignSources()
+	s.do(editStep{op: stepRemove, index: i, sticker: s.Stickers[i], source: s.Sources[i]})
+}
+
+// swapStickers exchanges the stickers at indexes i and j.
+func (s *UserSession) swapStickers(i, j int) {
+	s.do(editStep{
+		op:          stepSwap,
+		index:       i,
+		other:       j,
+		fileID:      s.Stickers[i].Sticker.FileID,
+		otherFileID: s.Stickers[j].Sticker.FileID,
+	})
+}
+
+// duplicateOf returns the position (1-based) of the session sticker with
+// the same source, or 0 if there is none.
+func (s *UserSession) duplicateOf(source StickerSource) int {
+	return s.indexOfSource(source.same) + 1
+}
+
+// lookalikeOf returns the position (1-based) of the session sticker that
+// looks like the source, or 0 if there is none.
+func (s *UserSession) lookalikeOf(source StickerSource) int {
+	return s.indexOfSource(source.looksLike) + 1
+}
+
+func (s *UserSession) indexOfSource(match func(StickerSource) bool) int {
+	for i := range min(len(s.Sources), len(s.Stickers)) {
+		if match(s.Sources[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// replyDuplicate tells the user the sticker they sent is already at pos.
+func (b *Bot) replyDuplicate(ctx context.Context, message telego.Message, pos int) {
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(message.Chat.ID),
+			fmt.Sprintf("This is already in your session as #%d.\n\nUse /list to see it.", pos),
+		).WithReplyParameters(&telego.ReplyParameters{MessageID: message.MessageID}))
+}
+
+// heldSticker is an uploaded image that looks like a session sticker,
+// waiting for its sender to add it anyway.
+type heldSticker struct {
+	userID  int64
+	sticker telego.InputSticker
+	source  StickerSource
+}
+
+// holdLookalike asks whether to add a sticker that looks like the one at
+// pos. Only the last such sticker of a session is kept.
+func (b *Bot) holdLookalike(ctx context.Context, message telego.Message, session *UserSession, sticker telego.InputSticker, source StickerSource, pos int) {
+	session.held = &heldSticker{userID: message.From.ID, sticker: sticker, source: source}
+
+	tag := stickerTag(sticker)
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(message.Chat.ID),
+			fmt.Sprintf("This looks like #%d in your session. Add it anyway?", pos),
+		).WithReplyParameters(&telego.ReplyParameters{MessageID: message.MessageID}).
+			WithReplyMarkup(tu.InlineKeyboard(tu.InlineKeyboardRow(
+				tu.InlineKeyboardButton("Add anyway").WithCallbackData("dup:add:"+tag),
+				tu.InlineKeyboardButton("Skip").WithCallbackData("dup:skip:"+tag),
+			))))
+}
+
+// handleDuplicateCallback handles the buttons of holdLookalike. Data has
+// the form "dup:<add|skip>:<tag>" with the stickerTag of the held sticker.
+func (b *Bot) handleDuplicateCallback(ctx context.Context, query telego.CallbackQuery) {
+	key, session := b.callbackSession(query)
+
+	parts := strings.Split(query.Data, ":")
+	held := session.held
+	if len(parts) != 3 || query.Message == nil || held == nil || stickerTag(held.sticker) != parts[2] {
+		_ = b.api.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID).WithText("This image is no longer waiting"))
+		return
+	}
+	if held.userID != query.From.ID {
+		_ = b.api.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID).WithText("Only the sender of the image can decide"))
+		return
+	}
+	session.held = nil
+	_ = b.api.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID))
+
+	chat := query.Message.GetChat()
+	_, _ = b.api.EditMessageReplyMarkup(ctx, tu.EditMessageReplyMarkup(tu.ID(chat.ID), query.Message.GetMessageID(), nil))
+	if parts[1] != "add" {
+		return
+	}
+
+	// Added as if the image was sent again
+	message := telego.Message{MessageID: query.Message.GetMessageID(), Chat: chat, From: &query.From}
+	if b.addSticker(ctx, message, key, session, held.sticker, held.source) {
+		b.promptEmoji(ctx, chat.ID, session, "Image converted and added!")
+	}
+}
@@ -0,0 +1,194 @@
+package telegramstickers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+const editorPageSize = 5
+
+// Editor callback actions, data has the form "edit:<action>:<tag>:<page>"
+// with the stickerTag of the sticker, or "-" for paging
+const (
+	editView  = "view"
+	editUp    = "up"
+	editDown  = "down"
+	editEmoji = "emoji"
+	editDel   = "del"
+	editPage  = "page"
+)
+
+func editorData(action, tag string, page int) string {
+	return fmt.Sprintf("edit:%s:%s:%d", action, tag, page)
+}
+
+func pageCount(total int) int {
+	return max(1, (total+editorPageSize-1)/editorPageSize)
+}
+
+// renderEditor builds the text and keyboard of one page of the session.
+func renderEditor(session *UserSession, page int) (string, *telego.InlineKeyboardMarkup) {
+	pages := pageCount(len(session.Stickers))
+	page = min(max(page, 0), pages-1)
+
+	if len(session.Stickers) == 0 {
+		return "Your session is empty.\n\nUse /add and send stickers to begin.", tu.InlineKeyboard()
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "📋 Your session: %d sticker(s)\n\n", len(session.Stickers))
+
+	rows := make([][]telego.InlineKeyboardButton, 0, editorPageSize+1)
+	start := page * editorPageSize
+	end := min(start+editorPageSize, len(session.Stickers))
+	for i := start; i < end; i++ {
+		pos := i + 1
+		sticker := session.Stickers[i]
+		emoji := strings.Join(sticker.EmojiList, "")
+
+		fmt.Fprintf(&text, "#%d %s %s", pos, emoji, sticker.Format)
+		if len(sticker.Keywords) > 0 {
+			fmt.Fprintf(&text, " (%s)", strings.Join(sticker.Keywords, ", "))
+		}
+		if session.isGroup() && i < len(session.Sources) && session.Sources[i].AddedByName != "" {
+			fmt.Fprintf(&text, " by %s", session.Sources[i].AddedByName)
+		}
+		text.WriteString("\n")
+
+		tag := stickerTag(sticker)
+		rows = append(rows, tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(fmt.Sprintf("#%d 👁", pos)).WithCallbackData(editorData(editView, tag, page)),
+			tu.InlineKeyboardButton("⬆️").WithCallbackData(editorData(editUp, tag, page)),
+			tu.InlineKeyboardButton("⬇️").WithCallbackData(editorData(editDown, tag, page)),
+			tu.InlineKeyboardButton(emoji+" ✏️").WithCallbackData(editorData(editEmoji, tag, page)),
+			tu.InlineKeyboardButton("🗑").WithCallbackData(editorData(editDel, tag, page)),
+		))
+	}
+
+	if pages > 1 {
+		rows = append(rows, tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton("◀️").WithCallbackData(editorData(editPage, "-", (page+pages-1)%pages)),
+			tu.InlineKeyboardButton(fmt.Sprintf("%d/%d", page+1, pages)).WithCallbackData(editorData(editPage, "-", page)),
+			tu.InlineKeyboardButton("▶️").WithCallbackData(editorData(editPage, "-", (page+1)%pages)),
+		))
+	}
+
+	text.WriteString("\nUse /create to make your pack.")
+	return text.String(), tu.InlineKeyboard(rows...)
+}
+
+func (b *Bot) handleList(ctx context.Context, message telego.Message) {
+	_, session := b.sessionFor(message)
+
+	if len(session.Stickers) == 0 {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"You haven't added any stickers yet.\n\nUse /add and send stickers to begin.",
+			))
+		return
+	}
+
+	text, keyboard := renderEditor(session, 0)
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(message.Chat.ID),
+			text,
+		).WithReplyMarkup(keyboard))
+}
+
+// handleEditorCallback applies an editor action and redraws the list in place.
+func (b *Bot) handleEditorCallback(ctx context.Context, query telego.CallbackQuery) {
+	key, session := b.callbackSession(query)
+
+	parts := strings.Split(query.Data, ":")
+	if len(parts) != 4 || query.Message == nil {
+		_ = b.api.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID))
+		return
+	}
+
+	action := parts[1]
+	// Positions may have changed since the list was sent, find the sticker
+	pos := session.indexOfTag(parts[2]) + 1
+	page, errPage := strconv.Atoi(parts[3])
+	if errPage != nil {
+		_ = b.api.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID))
+		return
+	}
+
+	if action != editPage && (pos < 1 || pos > len(session.Stickers)) {
+		_ = b.api.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID).WithText("This sticker is no longer in your session"))
+		b.redrawEditor(ctx, query, session, page)
+		return
+	}
+
+	// Group members may look around, only the owner edits
+	if action != editView && action != editPage && session.isGroup() && session.OwnerID != query.From.ID {
+		_ = b.api.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID).WithText("Only the owner of the group pack can edit it"))
+		return
+	}
+	if action != editView && action != editPage && b.jobPendingAnswer(ctx, query, session) {
+		return
+	}
+
+	chatID := tu.ID(query.Message.GetChat().ID)
+	answer := ""
+	changed := false
+	i := pos - 1
+
+	switch action {
+	case editView:
+		_, _ = b.api.SendSticker(ctx, tu.Sticker(chatID, session.Stickers[i].Sticker))
+	case editUp:
+		if i == 0 {
+			answer = "Already first"
+			break
+		}
+		session.swapStickers(i-1, i)
+		page = (i - 1) / editorPageSize
+		changed = true
+	case editDown:
+		if pos == len(session.Stickers) {
+			answer = "Already last"
+			break
+		}
+		session.swapStickers(i+1, i)
+		page = (i + 1) / editorPageSize
+		changed = true
+	case editEmoji:
+		session.awaitingEmoji = pos
+		answer = fmt.Sprintf("Send the new emoji for #%d", pos)
+		_, _ = b.api.SendMessage(ctx, tu.Message(chatID,
+			fmt.Sprintf("Send 1-%d emoji for sticker #%d, optionally followed by keywords.", maxEmojiPerSticker, pos)))
+	case editDel:
+		session.removeSticker(i)
+		session.awaitingEmoji = 0
+		answer = fmt.Sprintf("Sticker #%d removed", pos)
+		changed = true
+	}
+
+	if changed {
+		b.saveSession(key, session)
+	}
+
+	_ = b.api.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID).WithText(answer))
+	if changed || action == editPage {
+		b.redrawEditor(ctx, query, session, page)
+	}
+}
+
+func (b *Bot) redrawEditor(ctx context.Context, query telego.CallbackQuery, session *UserSession, page int) {
+	text, keyboard := renderEditor(session, page)
+	_, _ = b.api.EditMessageText(ctx, tu.EditMessageText(
+		tu.ID(query.Message.GetChat().ID),
+		query.Message.GetMessageID(),
+		text,
+	).WithReplyMarkup(keyboard))
+}
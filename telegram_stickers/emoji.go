@@ -0,0 +1,365 @@
+package telegramstickers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+const (
+	defaultEmoji = "😀"
+
+	maxEmojiPerSticker    = 20
+	maxKeywordsPerSticker = 20
+	maxKeywordsLength     = 64
+)
+
+// Emoji offered as one-tap choices after a sticker is added
+var quickEmoji = []string{"😂", "😍", "😢", "😡", "👍", "❤️"}
+
+var (
+	errNoEmoji        = errors.New("no emoji found, send at least one")
+	errTooManyEmoji   = fmt.Errorf("a sticker can have at most %d emoji", maxEmojiPerSticker)
+	errTooManyKeyword = fmt.Errorf("a sticker can have at most %d keywords", maxKeywordsPerSticker)
+	errKeywordsLength = fmt.Errorf("keywords can be at most %d characters in total", maxKeywordsLength)
+)
+
+func isEmojiBase(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF, // pictographs, emoticons, transport, flags...
+		r >= 0x2600 && r <= 0x27BF, // misc symbols and dingbats
+		r >= 0x2300 && r <= 0x23FF, // misc technical
+		r >= 0x2B00 && r <= 0x2BFF, // arrows, stars
+		r >= 0x2190 && r <= 0x21FF, // arrows
+		r == 0x00A9, r == 0x00AE, r == 0x203C, r == 0x2049, r == 0x2122,
+		r == 0x2139, r == 0x24C2, r == 0x3030, r == 0x303D, r == 0x3297, r == 0x3299:
+		return true
+	}
+	return false
+}
+
+// isEmojiModifier reports runes that extend the previous emoji instead of
+// starting a new one.
+func isEmojiModifier(r rune) bool {
+	switch {
+	case r == 0xFE0F, r == 0xFE0E, // variation selectors
+		r == 0x20E3,                  // combining keycap
+		r >= 0x1F3FB && r <= 0x1F3FF, // skin tones
+		r >= 0xE0020 && r <= 0xE007F: // tag sequences
+		return true
+	}
+	return false
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+func isKeycapBase(r rune) bool {
+	return r == '#' || r == '*' || (r >= '0' && r <= '9')
+}
+
+// splitEmoji splits s into emoji. ok is false if s contains anything that
+// is not part of an emoji.
+func splitEmoji(s string) (emojis []string, ok bool) {
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		start := i
+		r := runes[i]
+
+		switch {
+		case isRegionalIndicator(r):
+			// Flags are pairs of regional indicators
+			i++
+			if i < len(runes) && isRegionalIndicator(runes[i]) {
+				i++
+			}
+		case isKeycapBase(r):
+			// Only valid as the start of a keycap sequence
+			if i+1 >= len(runes) || !isEmojiModifier(runes[i+1]) {
+				return nil, false
+			}
+			i++
+		case isEmojiBase(r):
+			i++
+		default:
+			return nil, false
+		}
+
+		for i < len(runes) {
+			if isEmojiModifier(runes[i]) {
+				i++
+				continue
+			}
+			// Zero width joiner glues the next emoji into this one
+			if runes[i] == 0x200D && i+1 < len(runes) && isEmojiBase(runes[i+1]) {
+				i += 2
+				continue
+			}
+			break
+		}
+
+		emojis = append(emojis, string(runes[start:i]))
+	}
+	return emojis, len(emojis) > 0
+}
+
+// parseEmojiInput reads emoji and keywords from text like "😂🤣 funny lol".
+func parseEmojiInput(text string) (emojis []string, keywords []string, err error) {
+	for _, word := range strings.Fields(text) {
+		if found, ok := splitEmoji(word); ok {
+			emojis = append(emojis, found...)
+			continue
+		}
+		keywords = append(keywords, strings.ToLower(word))
+	}
+
+	if err := validateEmoji(emojis); err != nil {
+		return nil, nil, err
+	}
+	if err := validateKeywords(keywords); err != nil {
+		return nil, nil, err
+	}
+	return emojis, keywords, nil
+}
+
+func validateEmoji(emojis []string) error {
+	if len(emojis) == 0 {
+		return errNoEmoji
+	}
+	if len(emojis) > maxEmojiPerSticker {
+		return errTooManyEmoji
+	}
+	return nil
+}
+
+func validateKeywords(keywords []string) error {
+	if len(keywords) > maxKeywordsPerSticker {
+		return errTooManyKeyword
+	}
+
+	total := 0
+	for _, keyword := range keywords {
+		total += utf8.RuneCountInString(keyword)
+	}
+	if total > maxKeywordsLength {
+		return errKeywordsLength
+	}
+	return nil
+}
+
+// stickerTag is a short identity of a session sticker for callback data,
+// which has no room for a file ID. Buttons name stickers by tag rather than
+// position, the session may have changed since they were sent.
+func stickerTag(sticker telego.InputSticker) string {
+	h := fnv.New32a()
+	h.Write([]byte(sticker.Sticker.FileID))
+	return strconv.FormatUint(uint64(h.Sum32()), 36)
+}
+
+// indexOfTag returns the index of the sticker with the tag, or -1.
+func (s *UserSession) indexOfTag(tag string) int {
+	return slices.IndexFunc(s.Stickers, func(sticker telego.InputSticker) bool { return stickerTag(sticker) == tag })
+}
+
+// emojiKeyboard offers to keep the current emoji of the sticker or pick one
+// of the quick choices.
+func emojiKeyboard(sticker telego.InputSticker) *telego.InlineKeyboardMarkup {
+	tag := stickerTag(sticker)
+	quick := make([]telego.InlineKeyboardButton, 0, len(quickEmoji))
+	for _, emoji := range quickEmoji {
+		quick = append(quick, tu.InlineKeyboardButton(emoji).
+			WithCallbackData(fmt.Sprintf("emoji:%s:%s", tag, emoji)))
+	}
+
+	return tu.InlineKeyboard(
+		tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton("✅ Keep "+strings.Join(sticker.EmojiList, "")).
+				WithCallbackData(fmt.Sprintf("emoji:%s:keep", tag)),
+		),
+		quick,
+	)
+}
+
+// promptEmoji asks for the emoji of the sticker just added to the session,
+// the next emoji reply is for it. added tells what was added.
+func (b *Bot) promptEmoji(ctx context.Context, chatID int64, session *UserSession, added string) {
+	session.awaitingEmoji = len(session.Stickers)
+	sticker := session.Stickers[len(session.Stickers)-1]
+
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(chatID),
+			fmt.Sprintf("%s Total: %d\n\n"+
+				"Reply with 1-%d emoji for it, optionally followed by keywords "+
+				"(e.g. <code>😂🤣 funny lol</code>), or pick one below.", added, len(session.Stickers), maxEmojiPerSticker),
+		).WithParseMode("HTML").WithReplyMarkup(emojiKeyboard(sticker)),
+	)
+}
+
+// handleEmojiReply assigns emoji and keywords to the sticker that is waiting
+// for them. It never starts a session, most text is just chat.
+func (b *Bot) handleEmojiReply(ctx context.Context, message telego.Message) {
+	// Outside a private chat only replies to the bot can be emoji
+	private := message.Chat.Type == telego.ChatTypePrivate
+	if reply := message.ReplyToMessage; !private && (reply == nil || reply.From == nil || !reply.From.IsBot) {
+		return
+	}
+
+	key := b.sessionKey(message.Chat, message.From.ID)
+	session := b.findSession(key)
+	if !private && (session == nil || session.awaitingEmoji == 0 ||
+		session.isGroup() && message.From.ID != session.OwnerID) {
+		return
+	}
+
+	if session == nil || session.awaitingEmoji == 0 || session.awaitingEmoji > len(session.Stickers) {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"Send me a sticker, or use /emoji to set emoji for all stickers.",
+			))
+		return
+	}
+	if b.jobPending(ctx, message.Chat.ID, session) {
+		return
+	}
+
+	emojis, keywords, err := parseEmojiInput(message.Text)
+	if err != nil {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				fmt.Sprintf("Can't use that: %v\n\nSend 1-%d emoji, optionally followed by keywords.", err, maxEmojiPerSticker),
+			))
+		return
+	}
+
+	pos := session.awaitingEmoji
+	session.setEmoji(pos-1, emojis, keywords)
+	session.awaitingEmoji = 0
+	b.saveSession(key, session)
+
+	text := fmt.Sprintf("Sticker #%d emoji set to %s", pos, strings.Join(emojis, ""))
+	if len(keywords) > 0 {
+		text += fmt.Sprintf("\nKeywords: %s", strings.Join(keywords, ", "))
+	}
+
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(message.Chat.ID),
+			text+"\n\nSend more stickers or use /create to make your pack.",
+		))
+}
+
+// handleEmojiAll sets the same emoji and keywords for every sticker in the session.
+func (b *Bot) handleEmojiAll(ctx context.Context, message telego.Message) {
+	key, session := b.sessionFor(message)
+	if !b.ownerOnly(ctx, message.Chat.ID, session, message.From.ID) || b.jobPending(ctx, message.Chat.ID, session) {
+		return
+	}
+
+	_, _, payload := tu.ParseCommandPayload(message.Text)
+	if payload == "" {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"Usage: /emoji 😂🤣 [keywords...]\n\nSets the emoji and keywords of every sticker.",
+			))
+		return
+	}
+
+	if len(session.Stickers) == 0 {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"You haven't added any stickers yet.\n\nUse /add and send stickers to begin.",
+			))
+		return
+	}
+
+	emojis, keywords, err := parseEmojiInput(payload)
+	if err != nil {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				fmt.Sprintf("Can't use that: %v", err),
+			))
+		return
+	}
+
+	for i := range session.Stickers {
+		session.setEmoji(i, emojis, keywords)
+	}
+	session.awaitingEmoji = 0
+	b.saveSession(key, session)
+
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(message.Chat.ID),
+			fmt.Sprintf("Emoji of all %d sticker(s) set to %s", len(session.Stickers), strings.Join(emojis, "")),
+		))
+}
+
+// handleEmojiCallback handles the buttons of emojiKeyboard. Data has the
+// form "emoji:<tag>:<emoji|keep>".
+func (b *Bot) handleEmojiCallback(ctx context.Context, query telego.CallbackQuery) {
+	key, session := b.callbackSession(query)
+
+	parts := strings.SplitN(query.Data, ":", 3)
+	if len(parts) != 3 {
+		_ = b.api.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID))
+		return
+	}
+
+	if session.isGroup() && session.OwnerID != query.From.ID {
+		_ = b.api.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID).WithText("Only the owner of the group pack can set emoji"))
+		return
+	}
+
+	i := session.indexOfTag(parts[1])
+	if i < 0 {
+		_ = b.api.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID).WithText("This sticker is no longer in your session"))
+		return
+	}
+	pos := i + 1
+
+	sticker := &session.Stickers[pos-1]
+	if parts[2] != "keep" {
+		if b.jobPendingAnswer(ctx, query, session) {
+			return
+		}
+		session.setEmoji(pos-1, []string{parts[2]}, sticker.Keywords)
+		b.saveSession(key, session)
+	}
+	if session.awaitingEmoji == pos {
+		session.awaitingEmoji = 0
+	}
+
+	_ = b.api.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID).WithText("Emoji set to "+strings.Join(sticker.EmojiList, "")))
+
+	if query.Message != nil {
+		_, _ = b.api.EditMessageText(ctx, tu.EditMessageText(
+			tu.ID(query.Message.GetChat().ID),
+			query.Message.GetMessageID(),
+			fmt.Sprintf("Sticker #%d emoji: %s\n\nSend more stickers or use /create to make your pack.",
+				pos, strings.Join(sticker.EmojiList, "")),
+		))
+	}
+}
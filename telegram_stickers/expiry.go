@@ -0,0 +1,183 @@
+package telegramstickers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// How often idle sessions are looked for
+const janitorInterval = time.Minute
+
+// SessionLimits bounds how long sessions live and how much they hold. A
+// zero value disables that limit.
+type SessionLimits struct {
+	// Idle time after which a session is cleared, and how long before that
+	// its user is warned
+	TTL     time.Duration
+	Warning time.Duration
+
+	// Stickers one session may hold
+	MaxStickers int
+
+	// Sessions kept at once, the least recently used one is dropped to make
+	// room for a new one
+	MaxSessions int
+}
+
+// sessionNotice is a message about a session sent once the lock is released.
+type sessionNotice struct {
+	chatID int64
+	text   string
+}
+
+// touch marks the session as used now.
+func (s *UserSession) touch(now time.Time) {
+	s.LastUsed = now
+	s.warned = false
+}
+
+// dropSessionLocked removes a session from memory and the store. The caller
+// must hold b.mu.
+func (b *Bot) dropSessionLocked(key int64) {
+	if session := b.sessions[key]; session != nil {
+		session.dropped = true
+	}
+	delete(b.sessions, key)
+
+	if err := b.store.Delete(key); err != nil {
+		log.Printf("Error deleting session %d: %v", key, err)
+	}
+}
+
+// evictLocked drops least recently used sessions until there is room for a
+// new one. Sessions in use by a handler, with a pack job or of a group are
+// kept, there may be more sessions than the limit then. The caller must
+// hold b.mu.
+func (b *Bot) evictLocked() []sessionNotice {
+	if b.limits.MaxSessions <= 0 {
+		return nil
+	}
+
+	var notices []sessionNotice
+	for len(b.sessions) >= b.limits.MaxSessions {
+		var oldestKey int64
+		var oldest *UserSession
+		for key, session := range b.sessions {
+			if b.sessionBusyLocked(key) || session.Job != nil || session.isGroup() {
+				continue
+			}
+			if oldest == nil || session.LastUsed.Before(oldest.LastUsed) {
+				oldestKey, oldest = key, session
+			}
+		}
+		if oldest == nil {
+			break
+		}
+
+		b.dropSessionLocked(oldestKey)
+		if len(oldest.Stickers) > 0 {
+			notices = append(notices, sessionNotice{oldestKey, fmt.Sprintf(
+				"Your session with %d sticker(s) was cleared to make room, the bot is very busy right now. Sorry!",
+				len(oldest.Stickers))})
+		}
+	}
+	return notices
+}
+
+// sweepSessions clears sessions idle for longer than the TTL and warns
+// about those that are close to it. Sessions with a pack job are kept, the
+// job holds stickers already uploaded to a pack, and those a handler is
+// working on are left to the next sweep.
+func (b *Bot) sweepSessions(now time.Time) []sessionNotice {
+	if b.limits.TTL <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var notices []sessionNotice
+	for key, session := range b.sessions {
+		if session.Job != nil || b.sessionBusyLocked(key) {
+			continue
+		}
+		idle := now.Sub(session.LastUsed)
+		switch {
+		case idle >= b.limits.TTL:
+			b.dropSessionLocked(key)
+			if len(session.Stickers) > 0 {
+				notices = append(notices, sessionNotice{key, fmt.Sprintf(
+					"Your session with %d sticker(s) was cleared after %s without use.",
+					len(session.Stickers), formatIdle(b.limits.TTL))})
+			}
+
+		case idle >= b.limits.TTL-b.limits.Warning && !session.warned && len(session.Stickers) > 0:
+			session.warned = true
+			notices = append(notices, sessionNotice{key, fmt.Sprintf(
+				"Your session with %d sticker(s) will be cleared in about %s. "+
+					"Send /list to keep it, or /create to make your pack now.",
+				len(session.Stickers), formatIdle(b.limits.TTL-idle))})
+		}
+	}
+	return notices
+}
+
+// formatIdle rounds a duration for messages.
+func formatIdle(d time.Duration) string {
+	if d >= time.Hour {
+		return d.Round(time.Hour).String()
+	}
+	return max(d.Round(time.Minute), time.Minute).String()
+}
+
+func (b *Bot) sendNotices(ctx context.Context, notices []sessionNotice) {
+	for _, notice := range notices {
+		_, err := b.api.SendMessage(ctx, tu.Message(tu.ID(notice.chatID), notice.text))
+		if err != nil {
+			log.Printf("Error notifying session %d: %v", notice.chatID, err)
+		}
+	}
+}
+
+// janitor expires idle sessions until the bot is closed.
+func (b *Bot) janitor() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case now := <-ticker.C:
+			b.sendNotices(b.ctx, b.sweepSessions(now))
+		}
+	}
+}
+
+// hasRoom reports whether the session can take another sticker, pending
+// contributions count as well.
+func (b *Bot) hasRoom(session *UserSession) bool {
+	return b.limits.MaxStickers <= 0 || len(session.Stickers)+len(session.Pending) < b.limits.MaxStickers
+}
+
+// sessionFull reports whether the session can't take another sticker and
+// tells the chat so.
+func (b *Bot) sessionFull(ctx context.Context, chatID int64, session *UserSession) bool {
+	if b.hasRoom(session) {
+		return false
+	}
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(chatID),
+			fmt.Sprintf("Your session is full, it can hold at most %d stickers.\n\n"+
+				"Use /create to make packs of them first.", b.limits.MaxStickers),
+		))
+	return true
+}
@@ -0,0 +1,65 @@
+package telegramstickers
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestEvictKeepsSessionsInUse(t *testing.T) {
+	now := time.Now()
+	idle := func(minutes int) time.Time { return now.Add(-time.Duration(minutes) * time.Minute) }
+
+	plain := &UserSession{LastUsed: idle(50)}
+	sessions := map[int64]*UserSession{
+		1:  plain,
+		2:  {LastUsed: idle(90), Job: &PackJob{Name: "cats_by_testbot"}},
+		-3: {LastUsed: idle(90), OwnerID: 7},
+		4:  {LastUsed: idle(90)},
+		5:  {LastUsed: idle(10)},
+	}
+	b := &Bot{
+		sessions: sessions,
+		store:    NewMemoryStore(),
+		limits:   SessionLimits{MaxSessions: 5},
+		locks:    map[int64]*sessionLock{4: {refs: 1}},
+	}
+
+	keys := func() []int64 { return slices.Sorted(maps.Keys(b.sessions)) }
+
+	b.mu.Lock()
+	b.evictLocked()
+	b.mu.Unlock()
+	if got, want := keys(), []int64{-3, 2, 4, 5}; !slices.Equal(got, want) {
+		t.Errorf("sessions after evicting one = %v, want %v", got, want)
+	}
+	if !plain.dropped {
+		t.Error("evicted session isn't marked as dropped")
+	}
+
+	// Nothing else may go, the limit is exceeded instead
+	b.limits.MaxSessions = 2
+	b.mu.Lock()
+	b.evictLocked()
+	b.mu.Unlock()
+	if got, want := keys(), []int64{-3, 2, 4}; !slices.Equal(got, want) {
+		t.Errorf("sessions after evicting all it can = %v, want %v", got, want)
+	}
+}
+
+func TestDroppedSessionIsNotSaved(t *testing.T) {
+	store := openStore(t, t.TempDir())
+	defer store.Close()
+
+	session := testSession("cats", "c1")
+	b := &Bot{sessions: map[int64]*UserSession{1: session}, store: store}
+	b.saveSession(1, session)
+	b.clearSession(1)
+
+	// A handler that still holds the session finishes its edit
+	session.Stickers = append(session.Stickers, testSession("", "c2").Stickers...)
+	b.saveSession(1, session)
+
+	checkSessions(t, store, map[int64]*UserSession{})
+}
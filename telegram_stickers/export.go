@@ -0,0 +1,236 @@
+package telegramstickers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+const (
+	manifestFile = "manifest.json"
+
+	// Bots can't send documents bigger than this
+	maxUploadSize = 50 * 1024 * 1024
+)
+
+// manifest describes the stickers of an exported archive.
+type manifest struct {
+	Name        string            `json:"name,omitempty"`
+	Title       string            `json:"title,omitempty"`
+	StickerType string            `json:"sticker_type"`
+	Stickers    []manifestSticker `json:"stickers"`
+}
+
+// manifestSticker is one sticker of the archive, listed in pack order.
+type manifestSticker struct {
+	File         string               `json:"file"`
+	Format       string               `json:"format"`
+	Emoji        []string             `json:"emoji"`
+	Keywords     []string             `json:"keywords,omitempty"`
+	MaskPosition *telego.MaskPosition `json:"mask_position,omitempty"`
+}
+
+// fileFetcher downloads a file by ID and returns its data and server path.
+type fileFetcher func(fileID string) (data []byte, filePath string, err error)
+
+// fetchFile downloads a file from the Bot API file server.
+func (b *Bot) fetchFile(ctx context.Context, fileID string) ([]byte, string, error) {
+	file, err := b.api.GetFile(ctx, &telego.GetFileParams{FileID: fileID})
+	if err != nil {
+		return nil, "", fmt.Errorf("get file: %w", err)
+	}
+
+	data, err := tu.DownloadFile(b.api.FileDownloadURL(file.FilePath))
+	if err != nil {
+		return nil, "", fmt.Errorf("download file: %w", err)
+	}
+	return data, file.FilePath, nil
+}
+
+// formatExtension is used when the server path has no extension.
+func formatExtension(format string) string {
+	switch format {
+	case telego.StickerAnimated:
+		return ".tgs"
+	case telego.StickerVideo:
+		return ".webm"
+	}
+	return ".webp"
+}
+
+// buildArchive downloads the files of the stickers, which are in the order
+// of fileIDs, and zips them with the manifest. The File of each manifest
+// sticker is filled in.
+func buildArchive(m manifest, fileIDs []string, fetch fileFetcher) ([]byte, error) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+
+	for i, fileID := range fileIDs {
+		data, filePath, err := fetch(fileID)
+		if err != nil {
+			return nil, fmt.Errorf("sticker #%d: %w", i+1, err)
+		}
+
+		ext := strings.ToLower(path.Ext(filePath))
+		if ext == "" {
+			ext = formatExtension(m.Stickers[i].Format)
+		}
+		m.Stickers[i].File = fmt.Sprintf("%03d%s", i+1, ext)
+
+		w, err := archive.Create(m.Stickers[i].File)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+	}
+
+	w, err := archive.Create(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	if err := encoder.Encode(m); err != nil {
+		return nil, err
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sessionExport describes the session stickers for buildArchive.
+func sessionExport(session *UserSession) (manifest, []string) {
+	m := manifest{
+		Name:        session.PackName,
+		Title:       session.PackTitle,
+		StickerType: session.stickerType(),
+		Stickers:    make([]manifestSticker, 0, len(session.Stickers)),
+	}
+	fileIDs := make([]string, 0, len(session.Stickers))
+	for _, sticker := range session.Stickers {
+		m.Stickers = append(m.Stickers, manifestSticker{
+			Format:       sticker.Format,
+			Emoji:        sticker.EmojiList,
+			Keywords:     sticker.Keywords,
+			MaskPosition: sticker.MaskPosition,
+		})
+		fileIDs = append(fileIDs, sticker.Sticker.FileID)
+	}
+	return m, fileIDs
+}
+
+// setExport describes a sticker set for buildArchive, taking keywords from
+// the registry when the bot created the set.
+func (b *Bot) setExport(set *telego.StickerSet) (manifest, []string) {
+	indexed := make(map[string]IndexedSticker)
+	for _, sticker := range b.packs.Stickers(set.Name) {
+		indexed[sticker.FileUniqueID] = sticker
+	}
+
+	m := manifest{
+		Name:        set.Name,
+		Title:       set.Title,
+		StickerType: set.StickerType,
+		Stickers:    make([]manifestSticker, 0, len(set.Stickers)),
+	}
+	fileIDs := make([]string, 0, len(set.Stickers))
+	for _, sticker := range set.Stickers {
+		input := inputFromSticker(sticker)
+		entry := manifestSticker{
+			Format:       input.Format,
+			Emoji:        input.EmojiList,
+			MaskPosition: sticker.MaskPosition,
+		}
+		if known, ok := indexed[sticker.FileUniqueID]; ok {
+			entry.Emoji = known.Emoji
+			entry.Keywords = known.Keywords
+		}
+		m.Stickers = append(m.Stickers, entry)
+		fileIDs = append(fileIDs, sticker.FileID)
+	}
+	return m, fileIDs
+}
+
+// handleExport sends the session, or the named pack, as a zip archive.
+func (b *Bot) handleExport(ctx context.Context, message telego.Message) {
+	_, session := b.sessionFor(message)
+
+	_, _, args := tu.ParseCommand(message.Text)
+
+	var m manifest
+	var fileIDs []string
+	archiveName := "session.zip"
+
+	if len(args) > 0 {
+		name := strings.TrimPrefix(args[0], "https://t.me/addstickers/")
+		set, err := b.api.GetStickerSet(ctx, &telego.GetStickerSetParams{Name: name})
+		if err != nil {
+			log.Printf("Error getting sticker set %s: %v", name, err)
+			_, _ = b.api.SendMessage(
+				ctx,
+				tu.Message(
+					tu.ID(message.Chat.ID),
+					fmt.Sprintf("Pack <code>%s</code> not found.", html.EscapeString(name)),
+				).WithParseMode("HTML"))
+			return
+		}
+		m, fileIDs = b.setExport(set)
+		archiveName = set.Name + ".zip"
+	} else {
+		if len(session.Stickers) == 0 {
+			_, _ = b.api.SendMessage(
+				ctx,
+				tu.Message(
+					tu.ID(message.Chat.ID),
+					"Your session is empty.\n\nUse /export <pack_name> to export a pack instead.",
+				))
+			return
+		}
+		m, fileIDs = sessionExport(session)
+	}
+
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(message.Chat.ID),
+			fmt.Sprintf("Exporting %d sticker(s)...", len(fileIDs)),
+		))
+
+	data, err := buildArchive(m, fileIDs, func(fileID string) ([]byte, string, error) {
+		return b.fetchFile(ctx, fileID)
+	})
+	if err == nil && len(data) > maxUploadSize {
+		err = fmt.Errorf("the archive is %d MB, bots can only send up to 50 MB", len(data)/1024/1024)
+	}
+	if err != nil {
+		log.Printf("Error exporting stickers: %v", err)
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				fmt.Sprintf("Failed to export: %v", err),
+			))
+		return
+	}
+
+	_, err = b.api.SendDocument(ctx, tu.Document(
+		tu.ID(message.Chat.ID),
+		tu.FileFromBytes(data, archiveName),
+	).WithCaption(fmt.Sprintf("%d sticker(s), see %s for emoji and order.", len(fileIDs), manifestFile)))
+	if err != nil {
+		log.Printf("Error sending export: %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package telegramstickers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/mymmrac/telego"
+)
+
+const testToken = "123456:ABCDEFGHIJKLMNOPQRSTUVWXYZ012345678"
+
+// newFileServer serves getFile and the file downloads of a Bot API server
+// from files, keyed by file path. A file ID is its path without the
+// extension, IDs without a file get a path that isn't served.
+func newFileServer(t *testing.T, files map[string][]byte) *Bot {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/bot"+testToken+"/getFile", func(w http.ResponseWriter, r *http.Request) {
+		var params telego.GetFileParams
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filePath := "stickers/" + params.FileID
+		for p := range files {
+			if strings.TrimSuffix(p, path.Ext(p)) == filePath {
+				filePath = p
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"ok": true,
+			"result": telego.File{
+				FileID:       params.FileID,
+				FileUniqueID: params.FileID,
+				FilePath:     filePath,
+			},
+		})
+	})
+	mux.HandleFunc("/file/bot"+testToken+"/", func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[strings.TrimPrefix(r.URL.Path, "/file/bot"+testToken+"/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	api, err := telego.NewBot(testToken, telego.WithAPIServer(server.URL), telego.WithDiscardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Bot{api: api}
+}
+
+func readArchive(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], err = io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return files
+}
+
+func TestBuildArchive(t *testing.T) {
+	b := newFileServer(t, map[string][]byte{
+		"stickers/first.webp": []byte("static"),
+		"stickers/second.TGS": []byte("animated"),
+	})
+	fetch := func(fileID string) ([]byte, string, error) {
+		return b.fetchFile(context.Background(), fileID)
+	}
+
+	session := &UserSession{
+		PackName:  "cats_by_testbot",
+		PackTitle: "Cats",
+		Stickers: []telego.InputSticker{
+			{Sticker: telego.InputFile{FileID: "first"}, Format: telego.StickerStatic, EmojiList: []string{"😺"}},
+			{Sticker: telego.InputFile{FileID: "second"}, Format: telego.StickerAnimated,
+				EmojiList: []string{"😸", "😹"}, Keywords: []string{"laugh"}},
+		},
+	}
+	m, fileIDs := sessionExport(session)
+
+	data, err := buildArchive(m, fileIDs, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := readArchive(t, data)
+
+	if got := string(files["001.webp"]); got != "static" {
+		t.Errorf("001.webp = %q, want %q", got, "static")
+	}
+	if got := string(files["002.tgs"]); got != "animated" {
+		t.Errorf("002.tgs = %q, want %q", got, "animated")
+	}
+	if len(files) != 3 {
+		t.Errorf("archive has %d files, want 2 stickers and the manifest", len(files))
+	}
+
+	var got manifest
+	if err := json.Unmarshal(files[manifestFile], &got); err != nil {
+		t.Fatalf("read manifest: %v", err)
+	}
+	if got.Name != "cats_by_testbot" || got.Title != "Cats" || got.StickerType != telego.StickerTypeRegular {
+		t.Errorf("manifest describes %q %q %q", got.Name, got.Title, got.StickerType)
+	}
+	want := []manifestSticker{
+		{File: "001.webp", Format: telego.StickerStatic, Emoji: []string{"😺"}},
+		{File: "002.tgs", Format: telego.StickerAnimated, Emoji: []string{"😸", "😹"}, Keywords: []string{"laugh"}},
+	}
+	if len(got.Stickers) != len(want) {
+		t.Fatalf("manifest lists %d stickers, want %d", len(got.Stickers), len(want))
+	}
+	for i, sticker := range got.Stickers {
+		if sticker.File != want[i].File || sticker.Format != want[i].Format ||
+			strings.Join(sticker.Emoji, " ") != strings.Join(want[i].Emoji, " ") ||
+			strings.Join(sticker.Keywords, " ") != strings.Join(want[i].Keywords, " ") {
+			t.Errorf("sticker #%d = %+v, want %+v", i+1, sticker, want[i])
+		}
+	}
+}
+
+func TestBuildArchiveDownloadFails(t *testing.T) {
+	b := newFileServer(t, map[string][]byte{
+		"stickers/first.webp": []byte("static"),
+	})
+	fetch := func(fileID string) ([]byte, string, error) {
+		return b.fetchFile(context.Background(), fileID)
+	}
+
+	m := manifest{
+		StickerType: telego.StickerTypeRegular,
+		Stickers: []manifestSticker{
+			{Format: telego.StickerStatic},
+			{Format: telego.StickerStatic},
+		},
+	}
+	data, err := buildArchive(m, []string{"first", "gone"}, fetch)
+	if err == nil {
+		t.Fatalf("got a %d byte archive, want an error", len(data))
+	}
+	if !strings.Contains(err.Error(), "sticker #2") || !strings.Contains(err.Error(), "404") {
+		t.Errorf("error %q doesn't name sticker #2 and the failed download", err)
+	}
+}
@@ -0,0 +1,328 @@
+package telegramstickers
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// PendingSticker is a sticker a group member suggested, waiting for the
+// owner of the group session to approve it.
+type PendingSticker struct {
+	ID      int
+	Sticker telego.InputSticker
+	Source  StickerSource
+}
+
+func isGroupChat(chat telego.Chat) bool {
+	return chat.Type == telego.ChatTypeGroup || chat.Type == telego.ChatTypeSupergroup
+}
+
+// isGroup reports whether the session is shared by a group chat.
+func (s *UserSession) isGroup() bool {
+	return s.OwnerID != 0
+}
+
+// displayName is how a user is credited for their stickers.
+func displayName(user *telego.User) string {
+	if user.Username != "" {
+		return "@" + user.Username
+	}
+	return strings.TrimSpace(user.FirstName + " " + user.LastName)
+}
+
+// sessionKey returns the key of the session to work on: the group session
+// of the chat if it has one, otherwise the user's own.
+func (b *Bot) sessionKey(chat telego.Chat, userID int64) int64 {
+	if isGroupChat(chat) {
+		b.mu.RLock()
+		_, ok := b.sessions[chat.ID]
+		b.mu.RUnlock()
+		if ok {
+			return chat.ID
+		}
+	}
+	return userID
+}
+
+// sessionFor returns the session a message works on and its key.
+func (b *Bot) sessionFor(message telego.Message) (int64, *UserSession) {
+	key := b.sessionKey(message.Chat, message.From.ID)
+	return key, b.getSession(key)
+}
+
+// callbackSession returns the session a button press works on and its key.
+func (b *Bot) callbackSession(query telego.CallbackQuery) (int64, *UserSession) {
+	key := query.From.ID
+	if query.Message != nil {
+		key = b.sessionKey(query.Message.GetChat(), query.From.ID)
+	}
+	return key, b.getSession(key)
+}
+
+// ownerOnly reports whether userID may change the session. Everyone may
+// change their own session, only the owner may change a group session.
+func (b *Bot) ownerOnly(ctx context.Context, chatID int64, session *UserSession, userID int64) bool {
+	if !session.isGroup() || session.OwnerID == userID {
+		return true
+	}
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(chatID),
+			fmt.Sprintf("Only %s, the owner of this group pack, can do that.", session.OwnerName),
+		))
+	return false
+}
+
+// addSticker adds a sticker sent by the message author to the session and
+// credits them for it. In a group session stickers of other members wait
+// for the owner's approval, added is false then and the chat was told.
+func (b *Bot) addSticker(ctx context.Context, message telego.Message, key int64, session *UserSession, sticker telego.InputSticker, source StickerSource) (added bool) {
+	source = source.by(message.From)
+
+	if b.jobPending(ctx, message.Chat.ID, session) || b.sessionFull(ctx, message.Chat.ID, session) {
+		return false
+	}
+
+	if !session.isGroup() || session.OwnerID == message.From.ID {
+		session.appendSticker(sticker, source)
+		b.saveSession(key, session)
+		return true
+	}
+
+	if slices.ContainsFunc(session.Pending, func(p PendingSticker) bool { return p.Source.same(source) }) {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"This one was already suggested and is waiting for approval.",
+			).WithReplyParameters(&telego.ReplyParameters{MessageID: message.MessageID}))
+		return false
+	}
+
+	session.NextPendingID++
+	pending := PendingSticker{ID: session.NextPendingID, Sticker: sticker, Source: source}
+	session.Pending = append(session.Pending, pending)
+	b.saveSession(key, session)
+
+	_, err := b.api.SendSticker(ctx, tu.Sticker(tu.ID(message.Chat.ID), sticker.Sticker).
+		WithReplyMarkup(tu.InlineKeyboard(tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton("✅ Add").WithCallbackData(fmt.Sprintf("group:ok:%d", pending.ID)),
+			tu.InlineKeyboardButton("❌ Reject").WithCallbackData(fmt.Sprintf("group:no:%d", pending.ID)),
+		))))
+	if err != nil {
+		log.Printf("Error sending contribution: %v", err)
+	}
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(message.Chat.ID),
+			fmt.Sprintf("Thanks %s! %s will decide whether it goes into the pack.",
+				source.AddedByName, session.OwnerName),
+		))
+	return false
+}
+
+// handleGroupCallback handles the approval buttons of contributions. Data
+// has the form "group:<ok|no>:<id>".
+func (b *Bot) handleGroupCallback(ctx context.Context, query telego.CallbackQuery) {
+	parts := strings.Split(query.Data, ":")
+	if len(parts) != 3 || query.Message == nil {
+		_ = b.api.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID))
+		return
+	}
+	id, err := strconv.Atoi(parts[2])
+	if err != nil {
+		_ = b.api.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID))
+		return
+	}
+
+	key, session := b.callbackSession(query)
+	if !session.isGroup() || session.OwnerID != query.From.ID {
+		_ = b.api.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID).WithText("Only the owner of the group pack can decide"))
+		return
+	}
+
+	i := slices.IndexFunc(session.Pending, func(p PendingSticker) bool { return p.ID == id })
+	if i < 0 {
+		_ = b.api.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID).WithText("This suggestion was already handled"))
+		return
+	}
+	pending := session.Pending[i]
+	if parts[1] == "ok" && b.jobPendingAnswer(ctx, query, session) {
+		return
+	}
+	// The pending sticker itself is counted by hasRoom, so compare without it
+	if parts[1] == "ok" && b.limits.MaxStickers > 0 && len(session.Stickers) >= b.limits.MaxStickers {
+		_ = b.api.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID).WithText("The session is full, create a pack first"))
+		return
+	}
+	session.Pending = slices.Delete(session.Pending, i, i+1)
+
+	text := fmt.Sprintf("❌ The sticker from %s was rejected.", pending.Source.AddedByName)
+	if parts[1] == "ok" {
+		if pos := session.duplicateOf(pending.Source); pos > 0 {
+			text = fmt.Sprintf("The sticker from %s is already in the pack as #%d.", pending.Source.AddedByName, pos)
+		} else {
+			session.appendSticker(pending.Sticker, pending.Source)
+			text = fmt.Sprintf("✅ The sticker from %s was added as #%d.", pending.Source.AddedByName, len(session.Stickers))
+		}
+	}
+	b.saveSession(key, session)
+
+	_ = b.api.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID))
+	chatID := tu.ID(query.Message.GetChat().ID)
+	_, _ = b.api.EditMessageReplyMarkup(ctx, tu.EditMessageReplyMarkup(chatID, query.Message.GetMessageID(), nil))
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			chatID,
+			text,
+		))
+}
+
+// handleGroup starts a group session in a group chat or shows its status.
+// "/group owner" in reply to a member hands the session over, "/group stop"
+// ends it.
+func (b *Bot) handleGroup(ctx context.Context, message telego.Message) {
+	if !isGroupChat(message.Chat) {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"Add me to a group and send /group there to build a pack together.",
+			))
+		return
+	}
+
+	chatID := message.Chat.ID
+	_, _, args := tu.ParseCommand(message.Text)
+
+	b.mu.RLock()
+	session, ok := b.sessions[chatID]
+	b.mu.RUnlock()
+
+	if !ok {
+		session = b.getSession(chatID)
+		session.OwnerID = message.From.ID
+		session.OwnerName = displayName(message.From)
+		if session.PackTitle == "" {
+			session.PackTitle = message.Chat.Title
+		}
+		b.saveSession(chatID, session)
+
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(chatID),
+				fmt.Sprintf("<b>Group pack started!</b>\n\n"+
+					"Everyone can send stickers, photos and /text here. "+
+					"%s approves them, sets /title and /name and runs /create, the pack will be theirs.\n\n"+
+					"/group shows who added what, /group stop ends the session.",
+					html.EscapeString(session.OwnerName)),
+			).WithParseMode("HTML"))
+		return
+	}
+
+	switch {
+	case len(args) == 0:
+		b.sendGroupStatus(ctx, chatID, session)
+
+	case args[0] == "stop":
+		if !b.ownerOnly(ctx, chatID, session, message.From.ID) {
+			return
+		}
+		b.clearSession(chatID)
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(chatID),
+				"Group pack session ended.",
+			))
+
+	case args[0] == "owner":
+		if !b.ownerOnly(ctx, chatID, session, message.From.ID) {
+			return
+		}
+		reply := message.ReplyToMessage
+		if reply == nil || reply.From == nil || reply.From.IsBot {
+			_, _ = b.api.SendMessage(
+				ctx,
+				tu.Message(
+					tu.ID(chatID),
+					"Reply /group owner to a message of the member who should take over.",
+				))
+			return
+		}
+		if session.Job != nil {
+			// The parts already created belong to the current owner
+			_, _ = b.api.SendMessage(
+				ctx,
+				tu.Message(
+					tu.ID(chatID),
+					jobPendingText,
+				))
+			return
+		}
+		session.OwnerID = reply.From.ID
+		session.OwnerName = displayName(reply.From)
+		b.saveSession(chatID, session)
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(chatID),
+				fmt.Sprintf("%s now owns this group pack.", session.OwnerName),
+			))
+
+	default:
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(chatID),
+				"Usage: /group [stop|owner]",
+			))
+	}
+}
+
+// sendGroupStatus shows the owner, the contributors and what is pending.
+func (b *Bot) sendGroupStatus(ctx context.Context, chatID int64, session *UserSession) {
+	session.alignSources()
+
+	counts := make(map[string]int)
+	names := make([]string, 0)
+	for _, source := range session.Sources {
+		name := source.AddedByName
+		if name == "" {
+			name = "unknown"
+		}
+		if counts[name] == 0 {
+			names = append(names, name)
+		}
+		counts[name]++
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "<b>Group pack</b> owned by %s\n\n", html.EscapeString(session.OwnerName))
+	fmt.Fprintf(&text, "Stickers: %d\nWaiting for approval: %d\n", len(session.Stickers), len(session.Pending))
+	if len(names) > 0 {
+		text.WriteString("\n<b>Added by:</b>\n")
+		for _, name := range names {
+			fmt.Fprintf(&text, "%s: %d\n", html.EscapeString(name), counts[name])
+		}
+	}
+
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(chatID),
+			text.String(),
+		).WithParseMode("HTML"))
+}
@@ -0,0 +1,267 @@
+package telegramstickers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// Edits kept for /undo per session
+const maxHistory = 50
+
+type stepOp int
+
+const (
+	stepAdd stepOp = iota
+	stepRemove
+	stepSwap
+	stepEmoji
+)
+
+// editStep is one change to the stickers of a session, with what is needed
+// to take it back.
+type editStep struct {
+	op stepOp
+
+	// Index of the sticker, other is the one it was swapped with
+	index, other int
+
+	// FileIDs of the stickers at index and other before a swap or emoji
+	// change. Steps are recorded by index, the IDs keep them from applying
+	// to other stickers after the session changed some other way
+	fileID, otherFileID string
+
+	// The sticker added or removed
+	sticker telego.InputSticker
+	source  StickerSource
+
+	// Emoji and keywords after and before a stepEmoji
+	emoji, keywords       []string
+	oldEmoji, oldKeywords []string
+}
+
+// sessionEdit is everything one command changed, undone as a whole.
+type sessionEdit struct {
+	steps []editStep
+}
+
+func (e sessionEdit) String() string {
+	first := e.steps[0]
+	same := !slices.ContainsFunc(e.steps, func(step editStep) bool { return step.op != first.op })
+	if !same {
+		return fmt.Sprintf("%d changes", len(e.steps))
+	}
+
+	if len(e.steps) > 1 {
+		switch first.op {
+		case stepAdd:
+			return fmt.Sprintf("adding %d stickers", len(e.steps))
+		case stepRemove:
+			return fmt.Sprintf("removing %d stickers", len(e.steps))
+		case stepEmoji:
+			return fmt.Sprintf("emoji of %d stickers", len(e.steps))
+		}
+		return fmt.Sprintf("%d moves", len(e.steps))
+	}
+
+	switch first.op {
+	case stepAdd:
+		return fmt.Sprintf("adding sticker #%d", first.index+1)
+	case stepRemove:
+		return fmt.Sprintf("removing sticker #%d", first.index+1)
+	case stepEmoji:
+		return fmt.Sprintf("emoji of sticker #%d", first.index+1)
+	}
+	return fmt.Sprintf("moving sticker #%d to #%d", first.other+1, first.index+1)
+}
+
+// apply makes the step, or takes it back if forward is false. It reports
+// false if the session doesn't fit the step, nothing is changed then.
+func (st editStep) apply(s *UserSession, forward bool) bool {
+	s.alignSources()
+	at := func(i int, fileID string) bool {
+		return i < len(s.Stickers) && s.Stickers[i].Sticker.FileID == fileID
+	}
+
+	switch st.op {
+	case stepAdd, stepRemove:
+		// Adding and taking back a removal both insert the sticker
+		if (st.op == stepAdd) == forward {
+			if st.index > len(s.Stickers) {
+				return false
+			}
+			s.Stickers = slices.Insert(s.Stickers, st.index, st.sticker)
+			s.Sources = slices.Insert(s.Sources, st.index, st.source)
+			break
+		}
+		if !at(st.index, st.sticker.Sticker.FileID) {
+			return false
+		}
+		s.Stickers = slices.Delete(s.Stickers, st.index, st.index+1)
+		s.Sources = slices.Delete(s.Sources, st.index, st.index+1)
+
+	case stepSwap:
+		i, j := st.index, st.other
+		if !forward {
+			i, j = j, i
+		}
+		if !at(i, st.fileID) || !at(j, st.otherFileID) {
+			return false
+		}
+		s.Stickers[i], s.Stickers[j] = s.Stickers[j], s.Stickers[i]
+		s.Sources[i], s.Sources[j] = s.Sources[j], s.Sources[i]
+
+	case stepEmoji:
+		if !at(st.index, st.fileID) {
+			return false
+		}
+		if forward {
+			s.Stickers[st.index].EmojiList = st.emoji
+			s.Stickers[st.index].Keywords = st.keywords
+		} else {
+			s.Stickers[st.index].EmojiList = st.oldEmoji
+			s.Stickers[st.index].Keywords = st.oldKeywords
+		}
+	}
+	return true
+}
+
+// do makes a step and records it for the edit being made.
+func (s *UserSession) do(step editStep) {
+	step.apply(s, true)
+	s.editing = append(s.editing, step)
+}
+
+// setEmoji replaces the emoji and keywords of the sticker at index i.
+func (s *UserSession) setEmoji(i int, emoji, keywords []string) {
+	s.do(editStep{
+		op:          stepEmoji,
+		index:       i,
+		emoji:       emoji,
+		keywords:    keywords,
+		fileID:      s.Stickers[i].Sticker.FileID,
+		oldEmoji:    s.Stickers[i].EmojiList,
+		oldKeywords: s.Stickers[i].Keywords,
+	})
+}
+
+// forgetHistory drops the edits to undo and redo, for changes to the
+// stickers made without steps.
+func (s *UserSession) forgetHistory() {
+	s.history, s.undone, s.editing = nil, nil, nil
+}
+
+// commitEdit turns the steps made since the last save into one edit and
+// forgets what was undone before it.
+func (s *UserSession) commitEdit() {
+	if len(s.editing) == 0 {
+		return
+	}
+	s.history = append(s.history, sessionEdit{steps: s.editing})
+	if len(s.history) > maxHistory {
+		s.history = slices.Delete(s.history, 0, len(s.history)-maxHistory)
+	}
+	s.undone = nil
+	s.editing = nil
+}
+
+// undo takes back the last edit, ok is false if there is none. If the
+// session no longer matches the edit it is left as it was and the history
+// is dropped.
+func (s *UserSession) undo() (edit sessionEdit, ok bool, err error) {
+	s.commitEdit()
+	if len(s.history) == 0 {
+		return sessionEdit{}, false, nil
+	}
+
+	edit = s.history[len(s.history)-1]
+	for i := len(edit.steps) - 1; i >= 0; i-- {
+		if !edit.steps[i].apply(s, false) {
+			for _, step := range edit.steps[i+1:] {
+				step.apply(s, true)
+			}
+			s.history, s.undone = nil, nil
+			return edit, false, fmt.Errorf("the session changed in a way that can't be undone")
+		}
+	}
+	s.history = s.history[:len(s.history)-1]
+	s.undone = append(s.undone, edit)
+	s.awaitingEmoji = 0
+	return edit, true, nil
+}
+
+// redo makes the last undone edit again, ok is false if there is none.
+func (s *UserSession) redo() (edit sessionEdit, ok bool, err error) {
+	s.commitEdit()
+	if len(s.undone) == 0 {
+		return sessionEdit{}, false, nil
+	}
+
+	edit = s.undone[len(s.undone)-1]
+	for i, step := range edit.steps {
+		if !step.apply(s, true) {
+			for j := i - 1; j >= 0; j-- {
+				edit.steps[j].apply(s, false)
+			}
+			s.history, s.undone = nil, nil
+			return edit, false, fmt.Errorf("the session changed in a way that can't be redone")
+		}
+	}
+	s.undone = s.undone[:len(s.undone)-1]
+	s.history = append(s.history, edit)
+	s.awaitingEmoji = 0
+	return edit, true, nil
+}
+
+// handleUndo takes back the last change to the stickers of the session.
+func (b *Bot) handleUndo(ctx context.Context, message telego.Message) {
+	b.stepHistory(ctx, message, true)
+}
+
+// handleRedo makes the last undone change again.
+func (b *Bot) handleRedo(ctx context.Context, message telego.Message) {
+	b.stepHistory(ctx, message, false)
+}
+
+func (b *Bot) stepHistory(ctx context.Context, message telego.Message, undo bool) {
+	key, session := b.sessionFor(message)
+	if !b.ownerOnly(ctx, message.Chat.ID, session, message.From.ID) || b.jobPending(ctx, message.Chat.ID, session) {
+		return
+	}
+
+	var edit sessionEdit
+	var ok bool
+	var err error
+	if undo {
+		edit, ok, err = session.undo()
+	} else {
+		edit, ok, err = session.redo()
+	}
+
+	var text string
+	switch {
+	case err != nil:
+		text = fmt.Sprintf("Can't do that: %v.", err)
+	case !ok && undo:
+		text = "Nothing to undo."
+	case !ok:
+		text = "Nothing to redo."
+	case undo:
+		b.saveSession(key, session)
+		text = fmt.Sprintf("Undone: %s. Total: %d\n\n/redo brings it back, /list shows your stickers.",
+			edit, len(session.Stickers))
+	default:
+		b.saveSession(key, session)
+		text = fmt.Sprintf("Redone: %s. Total: %d\n\n/undo takes it back again.", edit, len(session.Stickers))
+	}
+
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(message.Chat.ID),
+			text,
+		))
+}
@@ -0,0 +1,240 @@
+package telegramstickers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	_ "image/jpeg"
+	"image/png"
+	"log"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// Static stickers must have one side of exactly 512px and the other at most 512px
+	stickerSide       = 512
+	maxStaticFileSize = 512 * 1024
+
+	// Bots can't download files bigger than this
+	maxDownloadSize = 20 * 1024 * 1024
+
+	// Largest image side decoded, a small file can declare a huge canvas
+	maxImageSide = 8192
+)
+
+var imageMimeTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/webp": true,
+}
+
+var errImageTooLarge = errors.New("image doesn't fit the sticker size limit even after compression")
+
+// imageSource returns the file of a photo or image document message.
+func imageSource(message telego.Message) (fileID string, size int, ok bool) {
+	if len(message.Photo) > 0 {
+		// Sizes are sorted, the last one is the original
+		photo := message.Photo[len(message.Photo)-1]
+		return photo.FileID, photo.FileSize, true
+	}
+
+	if message.Document != nil && imageMimeTypes[message.Document.MimeType] {
+		return message.Document.FileID, int(message.Document.FileSize), true
+	}
+
+	return "", 0, false
+}
+
+// downloadFile fetches a file from the Bot API file server.
+func (b *Bot) downloadFile(ctx context.Context, fileID string) ([]byte, error) {
+	data, _, err := b.fetchFile(ctx, fileID)
+	return data, err
+}
+
+// fitSize scales w x h so the longer side becomes exactly side.
+func fitSize(w, h, side int) (int, int) {
+	if w >= h {
+		return side, max(1, h*side/w)
+	}
+	return max(1, w*side/h), side
+}
+
+// decodeImage decodes a PNG, JPEG or WEBP image after checking from its
+// header that it isn't too big to hold in memory.
+func decodeImage(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+	if config.Width > maxImageSide || config.Height > maxImageSide {
+		return nil, fmt.Errorf("image is %dx%d, the limit is %dx%d",
+			config.Width, config.Height, maxImageSide, maxImageSide)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decode image: %w", err)
+	}
+	return src, nil
+}
+
+// convertImage decodes a PNG, JPEG or WEBP image and encodes it as a PNG
+// whose longer side is exactly side pixels and that fits in maxSize bytes.
+// With square set the image is centered on a transparent side x side canvas.
+func convertImage(data []byte, side, maxSize int, square bool) ([]byte, error) {
+	src, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	w, h := fitSize(bounds.Dx(), bounds.Dy(), side)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	target := dst.Bounds()
+	if square {
+		dst = image.NewNRGBA(image.Rect(0, 0, side, side))
+		target = image.Rect(0, 0, w, h).Add(image.Pt((side-w)/2, (side-h)/2))
+	}
+	draw.CatmullRom.Scale(dst, target, src, bounds, draw.Src, nil)
+
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+
+	var buf bytes.Buffer
+	if err := encoder.Encode(&buf, dst); err != nil {
+		return nil, fmt.Errorf("encode image: %w", err)
+	}
+	if buf.Len() <= maxSize {
+		return buf.Bytes(), nil
+	}
+
+	// Too big for a truecolor PNG, fall back to a paletted one
+	paletted := image.NewPaletted(dst.Bounds(), append(color.Palette{color.Transparent}, palette.WebSafe...))
+	draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), dst, image.Point{})
+
+	buf.Reset()
+	if err := encoder.Encode(&buf, paletted); err != nil {
+		return nil, fmt.Errorf("encode image: %w", err)
+	}
+	if buf.Len() > maxSize {
+		return nil, errImageTooLarge
+	}
+	return buf.Bytes(), nil
+}
+
+// uploadStickerFile uploads converted sticker data and returns its file ID,
+// so the sticker can be kept in the session like any other.
+func (b *Bot) uploadStickerFile(ctx context.Context, userID int64, data []byte, format string) (string, error) {
+	name := "sticker.png"
+	if format != telego.StickerStatic {
+		name = "sticker" + formatExtension(format)
+	}
+	file, err := b.api.UploadStickerFile(ctx, &telego.UploadStickerFileParams{
+		UserID:        userID,
+		Sticker:       tu.FileFromBytes(data, name),
+		StickerFormat: format,
+	})
+	if err != nil {
+		return "", fmt.Errorf("upload sticker file: %w", err)
+	}
+	return file.FileID, nil
+}
+
+// imageToSticker downloads an image, converts it for a pack of the given
+// type and uploads it, returning the file ID of the sticker.
+func (b *Bot) imageToSticker(ctx context.Context, userID int64, fileID string, stickerType string) (string, error) {
+	data, err := b.downloadFile(ctx, fileID)
+	if err != nil {
+		return "", err
+	}
+	return b.uploadImage(ctx, userID, data, stickerType)
+}
+
+// uploadImage converts image data for a pack of the given type and uploads
+// it, returning the file ID of the sticker.
+func (b *Bot) uploadImage(ctx context.Context, userID int64, data []byte, stickerType string) (string, error) {
+	// Custom emoji have a fixed square size, other stickers only fix the longer side
+	side, square := stickerSide, false
+	if stickerType == telego.StickerTypeCustomEmoji {
+		side, square = customEmojiSide, true
+	}
+
+	converted, err := convertImage(data, side, maxStaticFileSize, square)
+	if err != nil {
+		return "", err
+	}
+
+	return b.uploadStickerFile(ctx, userID, converted, telego.StickerStatic)
+}
+
+// handleImage turns a photo or image document into a static sticker.
+func (b *Bot) handleImage(ctx context.Context, message telego.Message) {
+	key, session := b.sessionFor(message)
+
+	fileID, size, _ := imageSource(message)
+	if size > maxDownloadSize {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"This image is too big, the limit is 20 MB.",
+			))
+		return
+	}
+
+	data, err := b.downloadFile(ctx, fileID)
+	if err != nil {
+		log.Printf("Error downloading image: %v", err)
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"Failed to download the image. Please try again.",
+			))
+		return
+	}
+
+	// An undecodable image is reported by the conversion below
+	hash, _ := imageHash(data)
+	source := StickerSource{Hash: hash, Digest: contentDigest(data)}
+	if pos := session.duplicateOf(source); pos > 0 {
+		b.replyDuplicate(ctx, message, pos)
+		return
+	}
+
+	stickerFileID, err := b.uploadImage(ctx, message.From.ID, data, session.stickerType())
+	if err != nil {
+		log.Printf("Error converting image: %v", err)
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				fmt.Sprintf("Can't make a sticker from this image: %v\n\nSend a PNG, JPEG or WEBP image.", err),
+			))
+		return
+	}
+
+	inputSticker := telego.InputSticker{
+		Sticker:   telego.InputFile{FileID: stickerFileID},
+		EmojiList: []string{defaultEmoji},
+		Format:    telego.StickerStatic,
+	}
+
+	if pos := session.lookalikeOf(source); pos > 0 {
+		b.holdLookalike(ctx, message, session, inputSticker, source, pos)
+		return
+	}
+
+	if !b.addSticker(ctx, message, key, session, inputSticker, source) {
+		return
+	}
+	b.promptEmoji(ctx, message.Chat.ID, session, "Image converted and added!")
+}
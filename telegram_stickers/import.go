@@ -0,0 +1,280 @@
+package telegramstickers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+const (
+	// Limits that keep a hostile archive from exhausting memory
+	maxImportEntries   = 200
+	maxImportEntrySize = 20 * 1024 * 1024
+)
+
+var errEntryTooLarge = errors.New("file is too large")
+
+// isArchive reports whether the message carries a zip file.
+func isArchive(_ context.Context, update telego.Update) bool {
+	if update.Message == nil || update.Message.Document == nil {
+		return false
+	}
+	document := update.Message.Document
+	return document.MimeType == "application/zip" ||
+		strings.HasSuffix(strings.ToLower(document.FileName), ".zip")
+}
+
+// importFormat returns the sticker format of an archive entry by extension,
+// or "" if the entry isn't a sticker.
+func importFormat(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".png", ".jpg", ".jpeg", ".webp":
+		return telego.StickerStatic
+	case ".tgs":
+		return telego.StickerAnimated
+	case ".webm":
+		return telego.StickerVideo
+	}
+	return ""
+}
+
+func readEntry(file *zip.File) ([]byte, error) {
+	if file.UncompressedSize64 > maxImportEntrySize {
+		return nil, errEntryTooLarge
+	}
+
+	r, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// The header can lie about the size, so limit the read as well
+	data, err := io.ReadAll(io.LimitReader(r, maxImportEntrySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImportEntrySize {
+		return nil, errEntryTooLarge
+	}
+	return data, nil
+}
+
+// readImportArchive lists the stickers of an archive. With a manifest its
+// order and metadata are used, otherwise every sticker file is taken in
+// name order with the default emoji.
+func readImportArchive(data []byte) (*manifest, map[string]*zip.File, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("not a valid zip archive: %w", err)
+	}
+
+	files := make(map[string]*zip.File)
+	var manifestEntry *zip.File
+	for _, file := range archive.File {
+		base := path.Base(file.Name)
+		if file.FileInfo().IsDir() || strings.HasPrefix(base, ".") || strings.HasPrefix(file.Name, "__MACOSX/") {
+			continue
+		}
+		if base == manifestFile {
+			manifestEntry = file
+			continue
+		}
+		files[file.Name] = file
+	}
+
+	m := &manifest{}
+	if manifestEntry != nil {
+		raw, err := readEntry(manifestEntry)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read %s: %w", manifestFile, err)
+		}
+		if err := json.Unmarshal(raw, m); err != nil {
+			return nil, nil, fmt.Errorf("invalid %s: %w", manifestFile, err)
+		}
+	} else {
+		names := make([]string, 0, len(files))
+		for name := range files {
+			if importFormat(name) != "" {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			m.Stickers = append(m.Stickers, manifestSticker{File: name})
+		}
+	}
+
+	if len(m.Stickers) > maxImportEntries {
+		return nil, nil, fmt.Errorf("the archive has %d stickers, at most %d can be imported at once",
+			len(m.Stickers), maxImportEntries)
+	}
+	return m, files, nil
+}
+
+// importEntry validates and uploads one archive entry for the session.
+func (b *Bot) importEntry(ctx context.Context, userID int64, session *UserSession, entry manifestSticker, file *zip.File) (telego.InputSticker, StickerSource, error) {
+	var source StickerSource
+	if file == nil {
+		return telego.InputSticker{}, source, fmt.Errorf("%s is missing from the archive", entry.File)
+	}
+
+	format := importFormat(entry.File)
+	if format == "" {
+		return telego.InputSticker{}, source, fmt.Errorf("%s is not a PNG, JPEG, WEBP, TGS or WEBM file", entry.File)
+	}
+
+	emojis := entry.Emoji
+	if len(emojis) == 0 {
+		emojis = []string{defaultEmoji}
+	}
+	if err := validateEmoji(emojis); err != nil {
+		return telego.InputSticker{}, source, err
+	}
+	if err := validateKeywords(entry.Keywords); err != nil {
+		return telego.InputSticker{}, source, err
+	}
+
+	data, err := readEntry(file)
+	if err != nil {
+		return telego.InputSticker{}, source, err
+	}
+
+	source.Digest = contentDigest(data)
+	if format == telego.StickerStatic {
+		if source.Hash, err = imageHash(data); err != nil {
+			return telego.InputSticker{}, source, err
+		}
+	}
+	if pos := session.duplicateOf(source); pos > 0 {
+		return telego.InputSticker{}, source, fmt.Errorf("already in your session as #%d", pos)
+	}
+
+	var fileID string
+	if format == telego.StickerStatic {
+		fileID, err = b.uploadImage(ctx, userID, data, session.stickerType())
+	} else {
+		if err := validateStickerFile(data, format, session.stickerType()); err != nil {
+			return telego.InputSticker{}, source, err
+		}
+		fileID, err = b.uploadStickerFile(ctx, userID, data, format)
+	}
+	if err != nil {
+		return telego.InputSticker{}, source, err
+	}
+
+	return telego.InputSticker{
+		Sticker:      telego.InputFile{FileID: fileID},
+		Format:       format,
+		EmojiList:    emojis,
+		Keywords:     entry.Keywords,
+		MaskPosition: entry.MaskPosition,
+	}, source, nil
+}
+
+// handleImport appends the stickers of a zip archive to the session and
+// reports the entries that couldn't be imported.
+func (b *Bot) handleImport(ctx context.Context, message telego.Message) {
+	key, session := b.sessionFor(message)
+	if !b.ownerOnly(ctx, message.Chat.ID, session, message.From.ID) || b.jobPending(ctx, message.Chat.ID, session) {
+		return
+	}
+
+	if message.Document.FileSize > maxDownloadSize {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"This archive is too big, the limit is 20 MB.",
+			))
+		return
+	}
+
+	data, err := b.downloadFile(ctx, message.Document.FileID)
+	if err != nil {
+		log.Printf("Error downloading archive: %v", err)
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"Failed to download the archive. Please try again.",
+			))
+		return
+	}
+
+	m, files, err := readImportArchive(data)
+	if err == nil && len(m.Stickers) == 0 {
+		err = errors.New("no stickers found in the archive")
+	}
+	if err == nil && m.StickerType != "" {
+		err = session.acceptType(m.StickerType)
+	}
+	if err != nil {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				fmt.Sprintf("Can't import: %v", err),
+			))
+		return
+	}
+
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(message.Chat.ID),
+			fmt.Sprintf("Importing %d sticker(s)...", len(m.Stickers)),
+		))
+
+	var failures, lookalikes strings.Builder
+	imported := 0
+	for i, entry := range m.Stickers {
+		if !b.hasRoom(session) {
+			fmt.Fprintf(&failures, "#%d and later: your session is full, it can hold at most %d stickers\n",
+				i+1, b.limits.MaxStickers)
+			break
+		}
+		sticker, source, err := b.importEntry(ctx, message.From.ID, session, entry, files[entry.File])
+		if err != nil {
+			fmt.Fprintf(&failures, "#%d %s: %v\n", i+1, entry.File, err)
+			continue
+		}
+		if pos := session.lookalikeOf(source); pos > 0 {
+			fmt.Fprintf(&lookalikes, "%s is #%d and looks like #%d\n", entry.File, len(session.Stickers)+1, pos)
+		}
+		session.appendSticker(sticker, source.by(message.From))
+		imported++
+	}
+	if session.PackTitle == "" && m.Title != "" {
+		session.PackTitle = m.Title
+	}
+	session.awaitingEmoji = 0
+	b.saveSession(key, session)
+
+	text := fmt.Sprintf("Imported %d of %d sticker(s). Total: %d", imported, len(m.Stickers), len(session.Stickers))
+	if failures.Len() > 0 {
+		text += "\n\nNot imported:\n" + failures.String()
+	}
+	if lookalikes.Len() > 0 {
+		text += "\n\nImported, but maybe duplicates:\n" + lookalikes.String()
+	}
+	text += "\n\nUse /list to review them or /create to make your pack."
+
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(message.Chat.ID),
+			text,
+		))
+}
@@ -0,0 +1,503 @@
+package telegramstickers
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// Thumbnails are 100x100 and at most 128 KB
+const (
+	thumbnailSide    = 100
+	maxThumbnailSize = 128 * 1024
+)
+
+const managePackHelpMsg = "/delsticker <n> - Delete sticker number n\n" +
+	"/move <n> <position> - Move a sticker\n" +
+	"/settitle <title> - Change the title\n" +
+	"/thumb - Reply to an image, or an emoji of an emoji pack, to make it the thumbnail, or send alone to reset it\n" +
+	"/replace <n> - Reply to a sticker or image to replace sticker n with it"
+
+// handleMyPacks lists the packs the user created with the bot.
+func (b *Bot) handleMyPacks(ctx context.Context, message telego.Message) {
+	packs := b.packs.Owned(message.From.ID)
+	if len(packs) == 0 {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"You haven't created any packs yet.\n\nUse /add and /create to make one.",
+			))
+		return
+	}
+
+	var text strings.Builder
+	text.WriteString("<b>Your packs:</b>\n\n")
+	rows := make([][]telego.InlineKeyboardButton, 0, len(packs))
+	for i, pack := range packs {
+		fmt.Fprintf(&text, "%d. <b>%s</b>\n%d %s, created %s, updated %s\nhttps://t.me/addstickers/%s\n\n",
+			i+1, html.EscapeString(pack.Title), pack.StickerCount, stickerTypeNames[pack.StickerType],
+			pack.CreatedAt.Format(time.DateOnly), pack.UpdatedAt.Format(time.DateOnly), pack.Name)
+		rows = append(rows, tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton(fmt.Sprintf("%d. %s", i+1, pack.Name)).
+				WithCallbackData("pack:select:"+packTag(pack.Name)),
+		))
+	}
+	text.WriteString("Pick a pack to manage it.")
+
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(message.Chat.ID),
+			text.String(),
+		).WithParseMode("HTML").WithReplyMarkup(tu.InlineKeyboard(rows...)))
+}
+
+// handlePack selects a pack by name for the management commands.
+func (b *Bot) handlePack(ctx context.Context, message telego.Message) {
+	_, _, args := tu.ParseCommand(message.Text)
+	if len(args) != 1 {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"Usage: /pack <pack_name>\n\nOr pick one from /mypacks.",
+			))
+		return
+	}
+
+	b.selectPack(ctx, message.From.ID, message.Chat.ID, strings.TrimPrefix(args[0], "https://t.me/addstickers/"))
+}
+
+func (b *Bot) selectPack(ctx context.Context, userID, chatID int64, name string) {
+	if !b.packs.IsOwner(userID, name) {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(chatID),
+				"You can only manage packs you created with this bot, see /mypacks.",
+			))
+		return
+	}
+
+	set, err := b.api.GetStickerSet(ctx, &telego.GetStickerSetParams{Name: name})
+	if err != nil {
+		log.Printf("Error getting sticker set %s: %v", name, err)
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(chatID),
+				"Failed to load the pack. Please try again.",
+			))
+		return
+	}
+
+	b.setManagedPack(userID, set.Name)
+
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(chatID),
+			fmt.Sprintf("<b>%s</b>\n%d sticker(s)\nhttps://t.me/addstickers/%s\n\n%s",
+				html.EscapeString(set.Title), len(set.Stickers), set.Name, html.EscapeString(managePackHelpMsg)),
+		).WithParseMode("HTML").WithReplyMarkup(tu.InlineKeyboard(
+			tu.InlineKeyboardRow(tu.InlineKeyboardButton("❌ Delete pack").WithCallbackData("pack:deleteset:"+packTag(set.Name))),
+		)))
+}
+
+// managedPack returns the pack userID picked, or "".
+func (b *Bot) managedPack(userID int64) string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.managed[userID]
+}
+
+// setManagedPack picks the pack userID manages, "" forgets it.
+func (b *Bot) setManagedPack(userID int64, name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if name == "" {
+		delete(b.managed, userID)
+		return
+	}
+	b.managed[userID] = name
+}
+
+// managedSet returns the selected pack, telling the user what's wrong if
+// there is none or it isn't theirs.
+func (b *Bot) managedSet(ctx context.Context, userID, chatID int64) (*telego.StickerSet, bool) {
+	name := b.managedPack(userID)
+
+	if name == "" {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(chatID),
+				"Pick a pack first with /mypacks or /pack <pack_name>.",
+			))
+		return nil, false
+	}
+
+	if !b.packs.IsOwner(userID, name) {
+		b.setManagedPack(userID, "")
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(chatID),
+				"You can only manage packs you created with this bot, see /mypacks.",
+			))
+		return nil, false
+	}
+
+	set, err := b.api.GetStickerSet(ctx, &telego.GetStickerSetParams{Name: name})
+	if err != nil {
+		log.Printf("Error getting sticker set %s: %v", name, err)
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(chatID),
+				"Failed to load the pack. Please try again.",
+			))
+		return nil, false
+	}
+	return set, true
+}
+
+// packTag is a short identity of a pack for callback data, set names can
+// be as long as the data itself.
+func packTag(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(name)))
+	return strconv.FormatUint(uint64(h.Sum32()), 36)
+}
+
+// ownedPack returns the name of the pack of userID with the tag.
+func (b *Bot) ownedPack(userID int64, tag string) (string, bool) {
+	for _, pack := range b.packs.Owned(userID) {
+		if packTag(pack.Name) == tag {
+			return pack.Name, true
+		}
+	}
+	return "", false
+}
+
+// stickerAt returns sticker n (1-based) of the set.
+func stickerAt(set *telego.StickerSet, arg string) (*telego.Sticker, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > len(set.Stickers) {
+		return nil, fmt.Errorf("there is no sticker #%s, the pack has %d", arg, len(set.Stickers))
+	}
+	return &set.Stickers[n-1], nil
+}
+
+// reportPackChange tells the user how a management action went.
+func (b *Bot) reportPackChange(ctx context.Context, chatID int64, err error, done string) {
+	text := done
+	if err != nil {
+		log.Printf("Error managing pack: %v", err)
+		text = fmt.Sprintf("Failed: %v", err)
+	}
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(chatID),
+			text,
+		))
+}
+
+func (b *Bot) handleDeleteSticker(ctx context.Context, message telego.Message) {
+	set, ok := b.managedSet(ctx, message.From.ID, message.Chat.ID)
+	if !ok {
+		return
+	}
+
+	_, _, args := tu.ParseCommand(message.Text)
+	if len(args) != 1 {
+		b.reportPackChange(ctx, message.Chat.ID, nil, "Usage: /delsticker <n>")
+		return
+	}
+
+	sticker, err := stickerAt(set, args[0])
+	if err == nil {
+		err = b.api.DeleteStickerFromSet(ctx, &telego.DeleteStickerFromSetParams{Sticker: sticker.FileID})
+	}
+	if err == nil {
+		b.indexPack(ctx, message.From.ID, set.Name, nil)
+	}
+	b.reportPackChange(ctx, message.Chat.ID, err, fmt.Sprintf("Sticker #%s deleted.", args[0]))
+}
+
+func (b *Bot) handleMoveSticker(ctx context.Context, message telego.Message) {
+	set, ok := b.managedSet(ctx, message.From.ID, message.Chat.ID)
+	if !ok {
+		return
+	}
+
+	_, _, args := tu.ParseCommand(message.Text)
+	if len(args) != 2 {
+		b.reportPackChange(ctx, message.Chat.ID, nil, "Usage: /move <n> <position>")
+		return
+	}
+
+	sticker, err := stickerAt(set, args[0])
+	if err == nil {
+		// The target position must be a valid sticker number too
+		_, err = stickerAt(set, args[1])
+	}
+	if err == nil {
+		pos, _ := strconv.Atoi(args[1])
+		err = b.api.SetStickerPositionInSet(ctx, &telego.SetStickerPositionInSetParams{
+			Sticker:  sticker.FileID,
+			Position: pos - 1,
+		})
+	}
+	if err == nil {
+		b.indexPack(ctx, message.From.ID, set.Name, nil)
+	}
+	b.reportPackChange(ctx, message.Chat.ID, err, fmt.Sprintf("Sticker #%s moved to position %s.", args[0], args[1]))
+}
+
+func (b *Bot) handleSetTitle(ctx context.Context, message telego.Message) {
+	set, ok := b.managedSet(ctx, message.From.ID, message.Chat.ID)
+	if !ok {
+		return
+	}
+
+	_, _, title := tu.ParseCommandPayload(message.Text)
+	err := validatePackTitle(title)
+	if err == nil {
+		err = b.api.SetStickerSetTitle(ctx, &telego.SetStickerSetTitleParams{
+			Name:  set.Name,
+			Title: strings.TrimSpace(title),
+		})
+	}
+	if err == nil {
+		b.indexPack(ctx, message.From.ID, set.Name, nil)
+	}
+	b.reportPackChange(ctx, message.Chat.ID, err, "Title changed to "+strings.TrimSpace(title))
+}
+
+// handleThumb sets the pack thumbnail from the replied image, or resets it
+// to the first sticker.
+func (b *Bot) handleThumb(ctx context.Context, message telego.Message) {
+	set, ok := b.managedSet(ctx, message.From.ID, message.Chat.ID)
+	if !ok {
+		return
+	}
+	if set.StickerType == telego.StickerTypeCustomEmoji {
+		b.setEmojiThumb(ctx, message, set)
+		return
+	}
+
+	params := &telego.SetStickerSetThumbnailParams{
+		Name:   set.Name,
+		UserID: message.From.ID,
+		Format: telego.StickerStatic,
+	}
+	if len(set.Stickers) > 0 {
+		params.Format = inputFromSticker(set.Stickers[0]).Format
+	}
+
+	var err error
+	if reply := message.ReplyToMessage; reply != nil {
+		fileID, _, isImage := imageSource(*reply)
+		if !isImage {
+			b.reportPackChange(ctx, message.Chat.ID, nil, "Reply /thumb to a photo or image file.")
+			return
+		}
+
+		var data []byte
+		data, err = b.downloadFile(ctx, fileID)
+		if err == nil {
+			data, err = convertImage(data, thumbnailSide, maxThumbnailSize, true)
+		}
+		if err == nil {
+			thumbnail := tu.FileFromBytes(data, "thumbnail.png")
+			params.Thumbnail = &thumbnail
+			params.Format = telego.StickerStatic
+		}
+	}
+
+	if err == nil {
+		err = b.api.SetStickerSetThumbnail(ctx, params)
+	}
+	if err == nil {
+		b.indexPack(ctx, message.From.ID, set.Name, nil)
+	}
+	b.reportPackChange(ctx, message.Chat.ID, err, "Thumbnail updated.")
+}
+
+// setEmojiThumb sets the thumbnail of a custom emoji pack, which can only be
+// one of its own emoji, to the replied one or resets it to the first.
+func (b *Bot) setEmojiThumb(ctx context.Context, message telego.Message, set *telego.StickerSet) {
+	params := &telego.SetCustomEmojiStickerSetThumbnailParams{Name: set.Name}
+
+	if reply := message.ReplyToMessage; reply != nil {
+		params.CustomEmojiID = customEmojiOf(*reply)
+		inSet := slices.ContainsFunc(set.Stickers, func(s telego.Sticker) bool {
+			return s.CustomEmojiID == params.CustomEmojiID
+		})
+		if params.CustomEmojiID == "" || !inSet {
+			b.reportPackChange(ctx, message.Chat.ID, nil, "Reply /thumb to an emoji of this pack.")
+			return
+		}
+	}
+
+	err := b.api.SetCustomEmojiStickerSetThumbnail(ctx, params)
+	if err == nil {
+		b.indexPack(ctx, message.From.ID, set.Name, nil)
+	}
+	b.reportPackChange(ctx, message.Chat.ID, err, "Thumbnail updated.")
+}
+
+// customEmojiOf returns the ID of the custom emoji a message is or starts
+// with, "" if it has none.
+func customEmojiOf(message telego.Message) string {
+	if message.Sticker != nil {
+		return message.Sticker.CustomEmojiID
+	}
+	for _, entity := range message.Entities {
+		if entity.Type == telego.EntityTypeCustomEmoji {
+			return entity.CustomEmojiID
+		}
+	}
+	return ""
+}
+
+// handleReplace swaps sticker n for the replied sticker or image.
+func (b *Bot) handleReplace(ctx context.Context, message telego.Message) {
+	set, ok := b.managedSet(ctx, message.From.ID, message.Chat.ID)
+	if !ok {
+		return
+	}
+
+	_, _, args := tu.ParseCommand(message.Text)
+	reply := message.ReplyToMessage
+	if len(args) != 1 || reply == nil {
+		b.reportPackChange(ctx, message.Chat.ID, nil, "Reply /replace <n> to the new sticker or image.")
+		return
+	}
+
+	old, err := stickerAt(set, args[0])
+	if err != nil {
+		b.reportPackChange(ctx, message.Chat.ID, err, "")
+		return
+	}
+
+	var sticker telego.InputSticker
+	switch fileID, _, isImage := imageSource(*reply); {
+	case reply.Sticker != nil:
+		if reply.Sticker.Type != set.StickerType {
+			b.reportPackChange(ctx, message.Chat.ID, nil,
+				fmt.Sprintf("This pack has %s, the new sticker must be one too.", stickerTypeNames[set.StickerType]))
+			return
+		}
+		sticker = inputFromSticker(*reply.Sticker)
+	case isImage:
+		var newFileID string
+		newFileID, err = b.imageToSticker(ctx, message.From.ID, fileID, set.StickerType)
+		sticker = telego.InputSticker{
+			Sticker: telego.InputFile{FileID: newFileID},
+			Format:  telego.StickerStatic,
+		}
+	default:
+		b.reportPackChange(ctx, message.Chat.ID, nil, "Reply /replace <n> to a sticker or image.")
+		return
+	}
+
+	// Keep the emoji of the sticker being replaced
+	if old.Emoji != "" {
+		sticker.EmojiList = []string{old.Emoji}
+	} else if len(sticker.EmojiList) == 0 {
+		sticker.EmojiList = []string{defaultEmoji}
+	}
+
+	if err == nil {
+		err = b.api.ReplaceStickerInSet(ctx, &telego.ReplaceStickerInSetParams{
+			UserID:     message.From.ID,
+			Name:       set.Name,
+			OldSticker: old.FileID,
+			Sticker:    prepareForType([]telego.InputSticker{sticker}, set.StickerType)[0],
+		})
+	}
+	if err == nil {
+		b.indexPack(ctx, message.From.ID, set.Name, nil)
+	}
+	b.reportPackChange(ctx, message.Chat.ID, err, fmt.Sprintf("Sticker #%s replaced.", args[0]))
+}
+
+// handlePackCallback handles the /mypacks buttons. Data is "pack:select:<tag>",
+// "pack:deleteset:<tag>", "pack:deleteset:<tag>:yes" or "pack:cancel",
+// where tag is the packTag of the pack.
+func (b *Bot) handlePackCallback(ctx context.Context, query telego.CallbackQuery) {
+	parts := strings.Split(query.Data, ":")
+	if query.Message == nil || len(parts) < 2 {
+		_ = b.api.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID))
+		return
+	}
+
+	chatID := query.Message.GetChat().ID
+	_ = b.api.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID))
+
+	switch {
+	case parts[1] == "select" && len(parts) == 3:
+		name, ok := b.ownedPack(query.From.ID, parts[2])
+		if !ok {
+			b.reportPackChange(ctx, chatID, nil, "This pack is gone, see /mypacks.")
+			return
+		}
+		b.selectPack(ctx, query.From.ID, chatID, name)
+
+	case parts[1] == "deleteset" && len(parts) == 3:
+		set, ok := b.managedSet(ctx, query.From.ID, chatID)
+		if !ok || packTag(set.Name) != parts[2] {
+			return
+		}
+		_, _ = b.api.EditMessageText(ctx, tu.EditMessageText(
+			tu.ID(chatID),
+			query.Message.GetMessageID(),
+			fmt.Sprintf("Delete %s for good? This can't be undone.", set.Title),
+		).WithReplyMarkup(tu.InlineKeyboard(tu.InlineKeyboardRow(
+			tu.InlineKeyboardButton("Yes, delete it").WithCallbackData("pack:deleteset:"+parts[2]+":yes"),
+			tu.InlineKeyboardButton("Cancel").WithCallbackData("pack:cancel"),
+		))))
+
+	case parts[1] == "deleteset" && len(parts) == 4 && parts[3] == "yes":
+		// The confirmation may be pressed after another pack was picked,
+		// only the pack it was asked for is deleted and only by its owner
+		name, owned := b.ownedPack(query.From.ID, parts[2])
+		set, ok := b.managedSet(ctx, query.From.ID, chatID)
+		if !ok {
+			return
+		}
+		if !owned || name != set.Name {
+			b.reportPackChange(ctx, chatID, nil, "This button is for another pack, pick it again with /mypacks.")
+			return
+		}
+		err := b.api.DeleteStickerSet(ctx, &telego.DeleteStickerSetParams{Name: set.Name})
+		if err == nil {
+			if err := b.packs.Remove(set.Name); err != nil {
+				log.Printf("Error removing pack %s from registry: %v", set.Name, err)
+			}
+			b.setManagedPack(query.From.ID, "")
+		}
+		b.reportPackChange(ctx, chatID, err, fmt.Sprintf("Pack %s deleted.", set.Title))
+
+	case parts[1] == "cancel":
+		_, _ = b.api.EditMessageText(ctx, tu.EditMessageText(
+			tu.ID(chatID),
+			query.Message.GetMessageID(),
+			"Cancelled.",
+		))
+	}
+}
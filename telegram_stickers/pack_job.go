@@ -0,0 +1,204 @@
+package telegramstickers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mymmrac/telego"
+	ta "github.com/mymmrac/telego/telegoapi"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+const (
+	// CreateNewStickerSet accepts at most this many stickers, the rest are added one by one
+	maxInitialStickers = 50
+
+	maxRegularSetSize     = 120
+	maxCustomEmojiSetSize = 200
+)
+
+// setCapacity returns how many stickers a set of the given type can hold.
+func setCapacity(stickerType string) int {
+	if stickerType == telego.StickerTypeCustomEmoji {
+		return maxCustomEmojiSetSize
+	}
+	return maxRegularSetSize
+}
+
+// PackJob uploads a session into one or more packs. It keeps its own copy
+// of the stickers and counts what was already uploaded, so a failed job
+// continues from the first sticker that didn't make it.
+type PackJob struct {
+	Name            string
+	Title           string
+	StickerType     string
+	NeedsRepainting bool
+	Stickers        []telego.InputSticker
+
+	// Number of parts already created and stickers already in them
+	Created int
+	Done    int
+}
+
+func newPackJob(session *UserSession, name, title string) *PackJob {
+	return &PackJob{
+		Name:            name,
+		Title:           title,
+		StickerType:     session.stickerType(),
+		NeedsRepainting: session.NeedsRepainting,
+		Stickers:        prepareForType(session.Stickers, session.stickerType()),
+	}
+}
+
+func (j *PackJob) partCount() int {
+	capacity := setCapacity(j.StickerType)
+	return (len(j.Stickers) + capacity - 1) / capacity
+}
+
+// partName returns the set name of part p (0-based). The first part keeps
+// the chosen name, the others get a number before the "_by_<bot>" suffix,
+// cutting the base name short if the result would be too long.
+func (j *PackJob) partName(p int) string {
+	if p == 0 {
+		return j.Name
+	}
+	idx := strings.LastIndex(j.Name, "_by_")
+	if idx < 0 {
+		idx = len(j.Name)
+	}
+	base, suffix := j.Name[:idx], fmt.Sprintf("_part%d%s", p+1, j.Name[idx:])
+	if keep := maxPackNameLength - len(suffix); len(base) > keep {
+		base = strings.TrimRight(base[:max(keep, 0)], "_")
+	}
+	return base + suffix
+}
+
+// partTitle returns the title of part p, cutting the chosen title short if
+// the part number wouldn't fit.
+func (j *PackJob) partTitle(p int) string {
+	if p == 0 {
+		return j.Title
+	}
+	suffix := fmt.Sprintf(" (part %d)", p+1)
+	title := []rune(j.Title)
+	if keep := maxPackTitleLength - utf8.RuneCountInString(suffix); len(title) > keep {
+		title = []rune(strings.TrimSpace(string(title[:keep])))
+	}
+	return string(title) + suffix
+}
+
+// partSize returns the number of stickers that go into part p.
+func (j *PackJob) partSize(p int) int {
+	capacity := setCapacity(j.StickerType)
+	return min(capacity, len(j.Stickers)-p*capacity)
+}
+
+// finished reports whether every sticker is in a pack.
+func (j *PackJob) finished() bool {
+	return j.Done >= len(j.Stickers)
+}
+
+// run uploads the remaining stickers. progress is called after every
+// successful API call so the job can be saved.
+func (j *PackJob) run(ctx context.Context, api *telego.Bot, userID int64, progress func()) error {
+	capacity := setCapacity(j.StickerType)
+
+	for !j.finished() {
+		p := j.Done / capacity
+		partEnd := min((p+1)*capacity, len(j.Stickers))
+
+		if p >= j.Created {
+			n := min(maxInitialStickers, partEnd-j.Done)
+			err := api.CreateNewStickerSet(ctx, &telego.CreateNewStickerSetParams{
+				UserID:          userID,
+				Name:            j.partName(p),
+				Title:           j.partTitle(p),
+				Stickers:        j.Stickers[j.Done : j.Done+n],
+				StickerType:     j.StickerType,
+				NeedsRepainting: j.NeedsRepainting,
+			})
+			if err != nil && nameOccupied(err) {
+				// The set was made by an attempt that failed before saving
+				// its progress
+				err = j.adoptPart(ctx, api, p)
+			} else if err == nil {
+				j.Done += n
+			}
+			if err != nil {
+				return fmt.Errorf("create %s: %w", j.partName(p), err)
+			}
+			j.Created = p + 1
+		} else {
+			err := api.AddStickerToSet(ctx, &telego.AddStickerToSetParams{
+				UserID:  userID,
+				Name:    j.partName(p),
+				Sticker: j.Stickers[j.Done],
+			})
+			if err != nil {
+				return fmt.Errorf("add sticker #%d to %s: %w", j.Done+1, j.partName(p), err)
+			}
+			j.Done++
+		}
+
+		progress()
+	}
+	return nil
+}
+
+// jobPendingText tells why a session with a half created pack can't change.
+const jobPendingText = "A pack is half created, finish it with /create or /clear it first."
+
+// jobPending reports whether the session waits for its pack job to be
+// resumed and tells the chat so. The job uploads its own copy of the
+// stickers and the session is cleared once it is done, so the stickers
+// can't change meanwhile.
+func (b *Bot) jobPending(ctx context.Context, chatID int64, session *UserSession) bool {
+	if session.Job == nil {
+		return false
+	}
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(chatID),
+			jobPendingText,
+		))
+	return true
+}
+
+// jobPendingAnswer is jobPending for button presses.
+func (b *Bot) jobPendingAnswer(ctx context.Context, query telego.CallbackQuery, session *UserSession) bool {
+	if session.Job == nil {
+		return false
+	}
+	_ = b.api.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID).WithText(jobPendingText))
+	return true
+}
+
+// nameOccupied reports whether err says a set with the name already exists.
+func nameOccupied(err error) bool {
+	var apiErr *ta.Error
+	return errors.As(err, &apiErr) && strings.Contains(apiErr.Description, "name is already occupied")
+}
+
+// adoptPart takes an existing set as part p if the bot made it, counting
+// the stickers already in it as done. The names of every part were free
+// when the job started, so a set of this bot under one is the job's own.
+func (j *PackJob) adoptPart(ctx context.Context, api *telego.Bot, p int) error {
+	botUser, err := api.GetMe(ctx)
+	if err != nil {
+		return err
+	}
+	name := j.partName(p)
+	if !strings.HasSuffix(strings.ToLower(name), strings.ToLower(packNameSuffix(botUser.Username))) {
+		return fmt.Errorf("set %s exists and wasn't made by this bot", name)
+	}
+	set, err := api.GetStickerSet(ctx, &telego.GetStickerSetParams{Name: name})
+	if err != nil {
+		return err
+	}
+	j.Done = p*setCapacity(j.StickerType) + min(len(set.Stickers), j.partSize(p))
+	return nil
+}
@@ -0,0 +1,198 @@
+package telegramstickers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mymmrac/telego"
+)
+
+// apiCall is a Bot API request seen by newAPIServer.
+type apiCall struct {
+	method string
+	params map[string]any
+}
+
+// apiRecorder collects the calls a test bot makes.
+type apiRecorder struct {
+	mu    sync.Mutex
+	calls []apiCall
+}
+
+func (r *apiRecorder) called(method string) []apiCall {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var ret []apiCall
+	for _, call := range r.calls {
+		if call.method == method {
+			ret = append(ret, call)
+		}
+	}
+	return ret
+}
+
+// newAPIServer returns a bot talking to a fake Bot API server that accepts
+// every upload and knows the sets in sets by name.
+func newAPIServer(t *testing.T, sets map[string]telego.StickerSet) (*Bot, *apiRecorder) {
+	t.Helper()
+
+	recorder := &apiRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		params := make(map[string]any)
+		_ = json.NewDecoder(r.Body).Decode(&params)
+
+		recorder.mu.Lock()
+		recorder.calls = append(recorder.calls, apiCall{method: method, params: params})
+		recorder.mu.Unlock()
+
+		var result any = true
+		switch method {
+		case "getMe":
+			result = telego.User{ID: 1000, IsBot: true, FirstName: "Test", Username: "testbot"}
+		case "sendMessage", "sendSticker":
+			result = telego.Message{MessageID: 1, Chat: telego.Chat{ID: 1, Type: telego.ChatTypePrivate}}
+		case "getStickerSet":
+			set, ok := sets[params["name"].(string)]
+			if !ok {
+				w.Header().Set("Content-Type", "application/json")
+				_ = json.NewEncoder(w).Encode(map[string]any{
+					"ok": false, "error_code": 400, "description": "Bad Request: STICKERSET_INVALID",
+				})
+				return
+			}
+			result = set
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+	}))
+	t.Cleanup(server.Close)
+
+	api, err := telego.NewBot(testToken, telego.WithAPIServer(server.URL), telego.WithDiscardLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	packs, err := NewPackRegistry(filepath.Join(t.TempDir(), "packs.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return &Bot{
+		api:      api,
+		sessions: make(map[int64]*UserSession),
+		store:    NewMemoryStore(),
+		packs:    packs,
+		managed:  make(map[int64]string),
+		locks:    make(map[int64]*sessionLock),
+		ctx:      ctx,
+		cancel:   cancel,
+	}, recorder
+}
+
+func userMessage(text string) telego.Message {
+	return telego.Message{
+		MessageID: 1,
+		Chat:      telego.Chat{ID: 1, Type: telego.ChatTypePrivate},
+		From:      &telego.User{ID: 1, FirstName: "Ann"},
+		Text:      text,
+	}
+}
+
+func testSticker(fileID string) telego.InputSticker {
+	return telego.InputSticker{
+		Sticker:   telego.InputFile{FileID: fileID},
+		Format:    telego.StickerStatic,
+		EmojiList: []string{"😺"},
+	}
+}
+
+// halfCreatedSession returns a session whose job created its pack with the
+// first of two stickers and failed on the second.
+func halfCreatedSession() *UserSession {
+	session := &UserSession{
+		Stickers: []telego.InputSticker{testSticker("first"), testSticker("second")},
+		Sources:  []StickerSource{{UniqueID: "first"}, {UniqueID: "second"}},
+	}
+	session.Job = newPackJob(session, "cats_by_testbot", "Cats")
+	session.Job.Created, session.Job.Done = 1, 1
+	return session
+}
+
+func TestResumeAfterEdits(t *testing.T) {
+	b, api := newAPIServer(t, map[string]telego.StickerSet{
+		"cats_by_testbot": {Name: "cats_by_testbot", Title: "Cats", StickerType: telego.StickerTypeRegular},
+	})
+	session := halfCreatedSession()
+	b.sessions[1] = session
+	ctx := context.Background()
+
+	// Edits between the failure and the resume are refused
+	sticker := userMessage("")
+	sticker.Sticker = &telego.Sticker{FileID: "third", FileUniqueID: "third", Type: telego.StickerTypeRegular, Emoji: "😸"}
+	b.handleSticker(ctx, sticker)
+	b.handleEmojiAll(ctx, userMessage("/emoji 😿"))
+	b.stepHistory(ctx, userMessage("/undo"), true)
+
+	if len(session.Stickers) != 2 || session.Stickers[0].EmojiList[0] != "😺" {
+		t.Fatalf("session changed while a job is pending: %+v", session.Stickers)
+	}
+	refused := 0
+	for _, call := range api.called("sendMessage") {
+		if call.params["text"] == jobPendingText {
+			refused++
+		}
+	}
+	if refused != 3 {
+		t.Errorf("%d of 3 edits were refused", refused)
+	}
+
+	b.handleCreate(ctx, userMessage("/create"))
+
+	if created := api.called("createNewStickerSet"); len(created) != 0 {
+		t.Errorf("resume created %d set(s), the pack exists already", len(created))
+	}
+	added := api.called("addStickerToSet")
+	if len(added) != 1 {
+		t.Fatalf("resume added %d sticker(s), want the one that failed", len(added))
+	}
+	if sticker := added[0].params["sticker"].(map[string]any); sticker["sticker"] != "second" {
+		t.Errorf("resume added %v, want the second sticker", sticker["sticker"])
+	}
+	if b.findSession(1) != nil {
+		t.Error("session is kept after the pack was finished")
+	}
+}
+
+func TestAddToKeepsJob(t *testing.T) {
+	b, api := newAPIServer(t, map[string]telego.StickerSet{
+		"dogs_by_testbot": {Name: "dogs_by_testbot", Title: "Dogs", StickerType: telego.StickerTypeRegular},
+	})
+	session := halfCreatedSession()
+	job := session.Job
+	b.sessions[1] = session
+	ctx := context.Background()
+
+	b.handleAddTo(ctx, userMessage("/addto dogs_by_testbot"))
+
+	if added := api.called("addStickerToSet"); len(added) != 2 {
+		t.Fatalf("/addto added %d sticker(s), want 2", len(added))
+	}
+	if b.findSession(1) != session || session.Job != job {
+		t.Fatal("/addto dropped the half created pack")
+	}
+	if len(session.Stickers) != 0 {
+		t.Errorf("session still has %d sticker(s) after they were all added", len(session.Stickers))
+	}
+	if job.Done != 1 || len(job.Stickers) != 2 {
+		t.Errorf("job changed to %d of %d done", job.Done, len(job.Stickers))
+	}
+}
@@ -0,0 +1,229 @@
+package telegramstickers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mymmrac/telego"
+	ta "github.com/mymmrac/telego/telegoapi"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+const (
+	maxPackNameLength  = 64
+	maxPackTitleLength = 64
+
+	// Number of alternatives offered when a name is taken
+	nameSuggestions = 3
+)
+
+// Set names start with a letter and contain only letters, digits and
+// single underscores
+var packNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(_[A-Za-z0-9]+)*$`)
+
+var (
+	errPackNameEmpty   = errors.New("name can't be empty")
+	errPackNameLength  = fmt.Errorf("name can be at most %d characters", maxPackNameLength)
+	errPackNameChars   = errors.New("name must start with a letter and contain only letters, digits and single underscores")
+	errPackTitleEmpty  = errors.New("title can't be empty")
+	errPackTitleLength = fmt.Errorf("title can be at most %d characters", maxPackTitleLength)
+)
+
+// packNameSuffix is the ending Telegram requires for sets created by the bot.
+func packNameSuffix(botUsername string) string {
+	return "_by_" + botUsername
+}
+
+// normalizePackName appends the bot suffix to name unless it's already there.
+func normalizePackName(name, botUsername string) string {
+	name = strings.TrimPrefix(strings.TrimSpace(name), "https://t.me/addstickers/")
+	suffix := packNameSuffix(botUsername)
+	if strings.HasSuffix(strings.ToLower(name), strings.ToLower(suffix)) {
+		return name
+	}
+	return name + suffix
+}
+
+func validatePackName(name string) error {
+	if name == "" {
+		return errPackNameEmpty
+	}
+	if len(name) > maxPackNameLength {
+		return errPackNameLength
+	}
+	if !packNamePattern.MatchString(name) {
+		return errPackNameChars
+	}
+	return nil
+}
+
+func validatePackTitle(title string) error {
+	if strings.TrimSpace(title) == "" {
+		return errPackTitleEmpty
+	}
+	if utf8.RuneCountInString(title) > maxPackTitleLength {
+		return errPackTitleLength
+	}
+	return nil
+}
+
+// packNameAvailable reports whether no set with this name exists yet.
+func (b *Bot) packNameAvailable(ctx context.Context, name string) (bool, error) {
+	_, err := b.api.GetStickerSet(ctx, &telego.GetStickerSetParams{Name: name})
+	if err == nil {
+		return false, nil
+	}
+
+	var apiErr *ta.Error
+	if errors.As(err, &apiErr) && strings.Contains(apiErr.Description, "STICKERSET_INVALID") {
+		return true, nil
+	}
+	return false, err
+}
+
+// suggestPackNames returns available variations of a taken name.
+func (b *Bot) suggestPackNames(ctx context.Context, name, botUsername string) []string {
+	base := strings.TrimSuffix(name, packNameSuffix(botUsername))
+	candidates := []string{
+		fmt.Sprintf("%s_%d", base, time.Now().Year()),
+		fmt.Sprintf("%s_%d", base, time.Now().Unix()%10000),
+	}
+	for i := 2; i <= 9; i++ {
+		candidates = append(candidates, fmt.Sprintf("%s_%d", base, i))
+	}
+
+	ret := make([]string, 0, nameSuggestions)
+	for _, candidate := range candidates {
+		full := candidate + packNameSuffix(botUsername)
+		if validatePackName(full) != nil {
+			continue
+		}
+		if ok, err := b.packNameAvailable(ctx, full); err == nil && ok {
+			ret = append(ret, full)
+		}
+		if len(ret) == nameSuggestions {
+			break
+		}
+	}
+	return ret
+}
+
+// handleName sets the short name used in the pack link.
+func (b *Bot) handleName(ctx context.Context, message telego.Message) {
+	key, session := b.sessionFor(message)
+	if !b.ownerOnly(ctx, message.Chat.ID, session, message.From.ID) {
+		return
+	}
+
+	_, _, args := tu.ParseCommand(message.Text)
+	if len(args) != 1 {
+		current := "not set, a name will be generated"
+		if session.PackName != "" {
+			current = session.PackName
+		}
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"Usage: /name <short_name>\n\n"+
+					"Sets the name used in the pack link. Letters, digits and underscores only.\n"+
+					"Current name: "+current,
+			))
+		return
+	}
+
+	botUser, err := b.api.GetMe(ctx)
+	if err != nil {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"Failed to get bot information. Please try again.",
+			))
+		return
+	}
+
+	name := normalizePackName(args[0], botUser.Username)
+	if err := validatePackName(name); err != nil {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				fmt.Sprintf("Invalid name <code>%s</code>: %v", html.EscapeString(name), err),
+			).WithParseMode("HTML"))
+		return
+	}
+
+	available, err := b.packNameAvailable(ctx, name)
+	if err != nil {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"Failed to check the name. Please try again.",
+			))
+		return
+	}
+
+	if !available {
+		text := fmt.Sprintf("Name <code>%s</code> is already taken.", html.EscapeString(name))
+		if suggestions := b.suggestPackNames(ctx, name, botUser.Username); len(suggestions) > 0 {
+			text += "\n\nAvailable alternatives:\n"
+			for _, suggestion := range suggestions {
+				text += fmt.Sprintf("<code>/name %s</code>\n", suggestion)
+			}
+		}
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				text,
+			).WithParseMode("HTML"))
+		return
+	}
+
+	session.PackName = name
+	b.saveSession(key, session)
+
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(message.Chat.ID),
+			fmt.Sprintf("Pack name set: <code>%s</code>\nLink: https://t.me/addstickers/%s", name, name),
+		).WithParseMode("HTML"))
+}
+
+// handleTitle sets the pack title shown in Telegram.
+func (b *Bot) handleTitle(ctx context.Context, message telego.Message) {
+	key, session := b.sessionFor(message)
+	if !b.ownerOnly(ctx, message.Chat.ID, session, message.From.ID) {
+		return
+	}
+
+	_, _, title := tu.ParseCommandPayload(message.Text)
+	if err := validatePackTitle(title); err != nil {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				fmt.Sprintf("Usage: /title <pack title>\n\n%v", err),
+			))
+		return
+	}
+
+	session.PackTitle = strings.TrimSpace(title)
+	b.saveSession(key, session)
+
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(message.Chat.ID),
+			"Pack title set: "+session.PackTitle,
+		))
+}
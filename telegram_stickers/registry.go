@@ -0,0 +1,232 @@
+package telegramstickers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PackRecord is a pack created by the bot.
+type PackRecord struct {
+	Name         string    `json:"name"`
+	OwnerID      int64     `json:"owner_id"`
+	Title        string    `json:"title"`
+	StickerType  string    `json:"sticker_type"`
+	StickerCount int       `json:"sticker_count"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	// Stickers of the pack in order, used for inline search
+	Stickers []IndexedSticker `json:"stickers,omitempty"`
+}
+
+// IndexedSticker is a sticker of a registered pack with what it can be
+// found by.
+type IndexedSticker struct {
+	FileID       string   `json:"file_id"`
+	FileUniqueID string   `json:"file_unique_id"`
+	Emoji        []string `json:"emoji"`
+	Keywords     []string `json:"keywords,omitempty"`
+}
+
+// PackRegistry remembers the packs the bot created and which user owns
+// each of them. It is kept in a single JSON file that is rewritten on
+// every change.
+type PackRegistry struct {
+	path  string
+	packs map[string]*PackRecord
+	mu    sync.RWMutex
+}
+
+func NewPackRegistry(path string) (*PackRegistry, error) {
+	r := &PackRegistry{
+		path:  path,
+		packs: make(map[string]*PackRecord),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read pack registry: %w", err)
+	}
+
+	var records []*PackRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("decode pack registry: %w", err)
+	}
+	for _, record := range records {
+		r.packs[strings.ToLower(record.Name)] = record
+	}
+	return r, nil
+}
+
+// save writes the registry, the caller must hold the lock.
+func (r *PackRegistry) save() error {
+	records := make([]*PackRecord, 0, len(r.packs))
+	for _, record := range r.packs {
+		records = append(records, record)
+	}
+	slices.SortFunc(records, func(a, b *PackRecord) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	data, err := json.MarshalIndent(records, "", "\t")
+	if err != nil {
+		return fmt.Errorf("encode pack registry: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return fmt.Errorf("create registry dir: %w", err)
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write pack registry: %w", err)
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// Record stores the current state of a pack owned by userID after it was
+// created or changed.
+func (r *PackRegistry) Record(userID int64, name, title, stickerType string, stickers []IndexedSticker) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	record, ok := r.packs[strings.ToLower(name)]
+	if !ok {
+		record = &PackRecord{CreatedAt: now}
+		r.packs[strings.ToLower(name)] = record
+	}
+
+	record.Name = name
+	record.OwnerID = userID
+	record.Title = title
+	record.StickerType = stickerType
+	record.StickerCount = len(stickers)
+	record.Stickers = stickers
+	record.UpdatedAt = now
+	return r.save()
+}
+
+// Stickers returns the indexed stickers of a pack.
+func (r *PackRegistry) Stickers(name string) []IndexedSticker {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	record, ok := r.packs[strings.ToLower(name)]
+	if !ok {
+		return nil
+	}
+	return slices.Clone(record.Stickers)
+}
+
+// Search returns up to limit stickers of userID's packs, starting at
+// offset, whose emoji or keywords match every word of the query. An empty
+// query matches everything. next is the offset of the following page or
+// -1 if there is none.
+func (r *PackRegistry) Search(userID int64, query string, offset, limit int) (found []IndexedSticker, next int) {
+	words := strings.Fields(strings.ToLower(query))
+	seen := make(map[string]bool)
+
+	matched := 0
+	for _, pack := range r.Owned(userID) {
+		for _, sticker := range pack.Stickers {
+			if seen[sticker.resultID()] || !sticker.matches(words) {
+				continue
+			}
+			seen[sticker.resultID()] = true
+
+			if matched >= offset {
+				if len(found) == limit {
+					return found, matched
+				}
+				found = append(found, sticker)
+			}
+			matched++
+		}
+	}
+	return found, -1
+}
+
+// resultID identifies the sticker in inline results. Stickers recorded
+// before their pack could be fetched have no unique ID yet.
+func (s IndexedSticker) resultID() string {
+	if s.FileUniqueID != "" {
+		return s.FileUniqueID
+	}
+	h := fnv.New64a()
+	h.Write([]byte(s.FileID))
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+// matches reports whether every word is one of the sticker emoji or the
+// start of one of its keywords.
+func (s IndexedSticker) matches(words []string) bool {
+	for _, word := range words {
+		ok := slices.ContainsFunc(s.Emoji, func(emoji string) bool {
+			// Emoji are often typed without the variation selector
+			return strings.ReplaceAll(emoji, "\uFE0F", "") == strings.ReplaceAll(word, "\uFE0F", "")
+		}) || slices.ContainsFunc(s.Keywords, func(keyword string) bool {
+			return strings.HasPrefix(keyword, word)
+		})
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Remove forgets a deleted pack.
+func (r *PackRegistry) Remove(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.packs, strings.ToLower(name))
+	return r.save()
+}
+
+// Owner returns the user the pack was created for, ok is false if the bot
+// doesn't know the pack.
+func (r *PackRegistry) Owner(name string) (userID int64, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	record, ok := r.packs[strings.ToLower(name)]
+	if !ok {
+		return 0, false
+	}
+	return record.OwnerID, true
+}
+
+// IsOwner reports whether the pack was created by the bot for userID.
+func (r *PackRegistry) IsOwner(userID int64, name string) bool {
+	owner, ok := r.Owner(name)
+	return ok && owner == userID
+}
+
+// Owned returns the packs of userID sorted by name.
+func (r *PackRegistry) Owned(userID int64) []PackRecord {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ret := make([]PackRecord, 0)
+	for _, record := range r.packs {
+		if record.OwnerID == userID {
+			ret = append(ret, *record)
+		}
+	}
+	slices.SortFunc(ret, func(a, b PackRecord) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return ret
+}
@@ -0,0 +1,114 @@
+package telegramstickers
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// Telegram shows at most 50 inline results per answer
+const inlinePageSize = 50
+
+// Fetching a pack to index it is tried this often, a pack just changed
+// can fail to load for a moment
+const (
+	indexAttempts   = 3
+	indexRetryDelay = 2 * time.Second
+)
+
+// indexPack fetches a pack and records it with its stickers. Stickers
+// already indexed keep their keywords, new ones at the end of the pack are
+// matched in order with added, the stickers just uploaded to it.
+func (b *Bot) indexPack(ctx context.Context, userID int64, name string, added []telego.InputSticker) {
+	var set *telego.StickerSet
+	var err error
+	for attempt := 1; ; attempt++ {
+		set, err = b.api.GetStickerSet(ctx, &telego.GetStickerSetParams{Name: name})
+		if err == nil || attempt == indexAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(indexRetryDelay):
+		}
+	}
+	if err != nil {
+		// The pack keeps what was recorded of it before, it is indexed
+		// again with its next change
+		log.Printf("Error indexing pack %s after %d attempts: %v", name, indexAttempts, err)
+		return
+	}
+
+	known := make(map[string]IndexedSticker)
+	for _, sticker := range b.packs.Stickers(set.Name) {
+		known[sticker.FileUniqueID] = sticker
+	}
+
+	firstAdded := len(set.Stickers) - len(added)
+	stickers := make([]IndexedSticker, 0, len(set.Stickers))
+	for i, sticker := range set.Stickers {
+		indexed := IndexedSticker{
+			FileID:       sticker.FileID,
+			FileUniqueID: sticker.FileUniqueID,
+			Emoji:        []string{sticker.Emoji},
+		}
+
+		if old, ok := known[sticker.FileUniqueID]; ok {
+			indexed.Emoji = old.Emoji
+			indexed.Keywords = old.Keywords
+		} else if i >= firstAdded {
+			input := added[i-firstAdded]
+			indexed.Emoji = input.EmojiList
+			indexed.Keywords = input.Keywords
+		}
+
+		stickers = append(stickers, indexed)
+	}
+
+	if err := b.packs.Record(userID, set.Name, set.Title, set.StickerType, stickers); err != nil {
+		log.Printf("Error registering pack %s: %v", set.Name, err)
+	}
+}
+
+// knownStickers indexes stickers by what the session knows of them, for a
+// pack that wasn't fetched yet. They get the pack's file IDs once it is
+// indexed.
+func knownStickers(stickers []telego.InputSticker) []IndexedSticker {
+	ret := make([]IndexedSticker, 0, len(stickers))
+	for _, sticker := range stickers {
+		ret = append(ret, IndexedSticker{
+			FileID:   sticker.Sticker.FileID,
+			Emoji:    sticker.EmojiList,
+			Keywords: sticker.Keywords,
+		})
+	}
+	return ret
+}
+
+// handleInlineQuery searches the stickers of the user's packs by emoji and keywords.
+func (b *Bot) handleInlineQuery(ctx context.Context, query telego.InlineQuery) {
+	offset, err := strconv.Atoi(query.Offset)
+	if err != nil {
+		offset = 0
+	}
+
+	found, next := b.packs.Search(query.From.ID, query.Query, offset, inlinePageSize)
+
+	results := make([]telego.InlineQueryResult, 0, len(found))
+	for _, sticker := range found {
+		results = append(results, tu.ResultCachedSticker(sticker.resultID(), sticker.FileID))
+	}
+
+	params := tu.InlineQuery(query.ID, results...).WithIsPersonal().WithCacheTime(10)
+	if next >= 0 {
+		params = params.WithNextOffset(strconv.Itoa(next))
+	}
+
+	if err := b.api.AnswerInlineQuery(ctx, params); err != nil {
+		log.Printf("Error answering inline query: %v", err)
+	}
+}
@@ -0,0 +1,362 @@
+package telegramstickers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mymmrac/telego"
+)
+
+// SessionStore persists user sessions so they survive restarts.
+type SessionStore interface {
+	// Load returns every stored session keyed by user ID.
+	Load() (map[int64]*UserSession, error)
+	// Save stores the current state of a session.
+	Save(userID int64, session *UserSession) error
+	// Delete removes a session.
+	Delete(userID int64) error
+	Close() error
+}
+
+// MemoryStore keeps nothing between restarts.
+type MemoryStore struct{}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (MemoryStore) Load() (map[int64]*UserSession, error) {
+	return make(map[int64]*UserSession), nil
+}
+
+func (MemoryStore) Save(int64, *UserSession) error { return nil }
+func (MemoryStore) Delete(int64) error             { return nil }
+func (MemoryStore) Close() error                   { return nil }
+
+const (
+	snapshotFile = "sessions.snapshot"
+	logFile      = "sessions.log"
+
+	// Number of log records after which the log is folded into the snapshot
+	compactThreshold = 500
+)
+
+// storedSticker is the on-disk form of telego.InputSticker. Only file IDs
+// are kept, uploaded file data can't be persisted.
+type storedSticker struct {
+	FileID       string               `json:"file_id"`
+	Format       string               `json:"format"`
+	EmojiList    []string             `json:"emoji_list"`
+	MaskPosition *telego.MaskPosition `json:"mask_position,omitempty"`
+	Keywords     []string             `json:"keywords,omitempty"`
+}
+
+type storedSession struct {
+	Stickers        []storedSticker `json:"stickers"`
+	Sources         []StickerSource `json:"sources,omitempty"`
+	PackName        string          `json:"pack_name,omitempty"`
+	PackTitle       string          `json:"pack_title,omitempty"`
+	StickerType     string          `json:"sticker_type,omitempty"`
+	NeedsRepainting bool            `json:"needs_repainting,omitempty"`
+	Job             *storedJob      `json:"job,omitempty"`
+	OwnerID         int64           `json:"owner_id,omitempty"`
+	OwnerName       string          `json:"owner_name,omitempty"`
+	Pending         []storedPending `json:"pending,omitempty"`
+	NextPendingID   int             `json:"next_pending_id,omitempty"`
+	LastUsed        time.Time       `json:"last_used,omitzero"`
+}
+
+type storedPending struct {
+	ID      int           `json:"id"`
+	Sticker storedSticker `json:"sticker"`
+	Source  StickerSource `json:"source"`
+}
+
+type storedJob struct {
+	Name            string          `json:"name"`
+	Title           string          `json:"title"`
+	StickerType     string          `json:"sticker_type"`
+	NeedsRepainting bool            `json:"needs_repainting,omitempty"`
+	Stickers        []storedSticker `json:"stickers"`
+	Created         int             `json:"created"`
+	Done            int             `json:"done"`
+}
+
+type logRecord struct {
+	Op      string         `json:"op"`
+	UserID  int64          `json:"user_id"`
+	Session *storedSession `json:"session,omitempty"`
+}
+
+const (
+	opSave   = "save"
+	opDelete = "delete"
+)
+
+func toStoredStickers(stickers []telego.InputSticker) []storedSticker {
+	ret := make([]storedSticker, 0, len(stickers))
+	for _, sticker := range stickers {
+		ret = append(ret, storedSticker{
+			FileID:       sticker.Sticker.FileID,
+			Format:       sticker.Format,
+			EmojiList:    sticker.EmojiList,
+			MaskPosition: sticker.MaskPosition,
+			Keywords:     sticker.Keywords,
+		})
+	}
+	return ret
+}
+
+func fromStoredStickers(stickers []storedSticker) []telego.InputSticker {
+	ret := make([]telego.InputSticker, 0, len(stickers))
+	for _, sticker := range stickers {
+		ret = append(ret, telego.InputSticker{
+			Sticker:      telego.InputFile{FileID: sticker.FileID},
+			Format:       sticker.Format,
+			EmojiList:    sticker.EmojiList,
+			MaskPosition: sticker.MaskPosition,
+			Keywords:     sticker.Keywords,
+		})
+	}
+	return ret
+}
+
+func toStored(session *UserSession) *storedSession {
+	s := &storedSession{
+		Stickers:        toStoredStickers(session.Stickers),
+		Sources:         session.Sources,
+		PackName:        session.PackName,
+		PackTitle:       session.PackTitle,
+		StickerType:     session.StickerType,
+		NeedsRepainting: session.NeedsRepainting,
+		OwnerID:         session.OwnerID,
+		OwnerName:       session.OwnerName,
+		NextPendingID:   session.NextPendingID,
+		LastUsed:        session.LastUsed,
+	}
+	for _, pending := range session.Pending {
+		s.Pending = append(s.Pending, storedPending{
+			ID:      pending.ID,
+			Sticker: toStoredStickers([]telego.InputSticker{pending.Sticker})[0],
+			Source:  pending.Source,
+		})
+	}
+	if job := session.Job; job != nil {
+		s.Job = &storedJob{
+			Name:            job.Name,
+			Title:           job.Title,
+			StickerType:     job.StickerType,
+			NeedsRepainting: job.NeedsRepainting,
+			Stickers:        toStoredStickers(job.Stickers),
+			Created:         job.Created,
+			Done:            job.Done,
+		}
+	}
+	return s
+}
+
+func fromStored(s *storedSession) *UserSession {
+	session := &UserSession{
+		Stickers:        fromStoredStickers(s.Stickers),
+		Sources:         s.Sources,
+		PackName:        s.PackName,
+		PackTitle:       s.PackTitle,
+		StickerType:     s.StickerType,
+		NeedsRepainting: s.NeedsRepainting,
+		OwnerID:         s.OwnerID,
+		OwnerName:       s.OwnerName,
+		NextPendingID:   s.NextPendingID,
+		LastUsed:        s.LastUsed,
+	}
+	for _, pending := range s.Pending {
+		session.Pending = append(session.Pending, PendingSticker{
+			ID:      pending.ID,
+			Sticker: fromStoredStickers([]storedSticker{pending.Sticker})[0],
+			Source:  pending.Source,
+		})
+	}
+	if job := s.Job; job != nil {
+		session.Job = &PackJob{
+			Name:            job.Name,
+			Title:           job.Title,
+			StickerType:     job.StickerType,
+			NeedsRepainting: job.NeedsRepainting,
+			Stickers:        fromStoredStickers(job.Stickers),
+			Created:         job.Created,
+			Done:            job.Done,
+		}
+	}
+	session.alignSources()
+	return session
+}
+
+// FileStore keeps sessions in a directory as a snapshot plus an append-only
+// log of changes made since the snapshot was written.
+type FileStore struct {
+	dir      string
+	sessions map[int64]*storedSession
+	log      *os.File
+	records  int
+	mu       sync.Mutex
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create session dir: %w", err)
+	}
+
+	s := &FileStore{
+		dir:      dir,
+		sessions: make(map[int64]*storedSession),
+	}
+
+	if err := s.readSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := s.replayLog(); err != nil {
+		return nil, err
+	}
+
+	// Start every run from a fresh snapshot and an empty log
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *FileStore) readSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &s.sessions); err != nil {
+		return fmt.Errorf("decode snapshot: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) replayLog() error {
+	file, err := os.Open(filepath.Join(s.dir, logFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open session log: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var record logRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// A torn write at the end of the log is expected after a crash
+			break
+		}
+		s.apply(record)
+	}
+	return scanner.Err()
+}
+
+func (s *FileStore) apply(record logRecord) {
+	switch record.Op {
+	case opSave:
+		if record.Session != nil {
+			s.sessions[record.UserID] = record.Session
+		}
+	case opDelete:
+		delete(s.sessions, record.UserID)
+	}
+}
+
+// compact writes the current state to the snapshot and truncates the log.
+func (s *FileStore) compact() error {
+	data, err := json.Marshal(s.sessions)
+	if err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+
+	tmp := filepath.Join(s.dir, snapshotFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, snapshotFile)); err != nil {
+		return fmt.Errorf("replace snapshot: %w", err)
+	}
+
+	if s.log != nil {
+		s.log.Close()
+	}
+	s.log, err = os.Create(filepath.Join(s.dir, logFile))
+	if err != nil {
+		return fmt.Errorf("create session log: %w", err)
+	}
+	s.records = 0
+	return nil
+}
+
+func (s *FileStore) append(record logRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := s.log.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("append session log: %w", err)
+	}
+
+	s.apply(record)
+	s.records++
+	if s.records >= compactThreshold {
+		return s.compact()
+	}
+	return nil
+}
+
+func (s *FileStore) Load() (map[int64]*UserSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ret := make(map[int64]*UserSession, len(s.sessions))
+	for userID, session := range s.sessions {
+		ret[userID] = fromStored(session)
+	}
+	return ret, nil
+}
+
+func (s *FileStore) Save(userID int64, session *UserSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.append(logRecord{Op: opSave, UserID: userID, Session: toStored(session)})
+}
+
+func (s *FileStore) Delete(userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessions[userID]; !ok {
+		return nil
+	}
+	return s.append(logRecord{Op: opDelete, UserID: userID})
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.compact(); err != nil {
+		return err
+	}
+	return s.log.Close()
+}
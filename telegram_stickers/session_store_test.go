@@ -0,0 +1,165 @@
+package telegramstickers
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mymmrac/telego"
+)
+
+func openStore(t *testing.T, dir string) *FileStore {
+	t.Helper()
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore() = %v", err)
+	}
+	return store
+}
+
+func testSession(name string, fileIDs ...string) *UserSession {
+	session := &UserSession{PackName: name}
+	for _, fileID := range fileIDs {
+		session.Stickers = append(session.Stickers, telego.InputSticker{
+			Sticker:   telego.InputFile{FileID: fileID},
+			Format:    telego.StickerStatic,
+			EmojiList: []string{"😺"},
+		})
+	}
+	return session
+}
+
+// checkSessions compares the pack names and sticker file IDs of the loaded
+// sessions with want.
+func checkSessions(t *testing.T, store *FileStore, want map[int64]*UserSession) {
+	t.Helper()
+
+	got, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	if len(got) != len(want) {
+		t.Errorf("Load() returned %d session(s), want %d", len(got), len(want))
+	}
+	for key, w := range want {
+		g, ok := got[key]
+		if !ok {
+			t.Errorf("session %d is missing", key)
+			continue
+		}
+		if g.PackName != w.PackName || len(g.Stickers) != len(w.Stickers) {
+			t.Errorf("session %d = %q with %d sticker(s), want %q with %d",
+				key, g.PackName, len(g.Stickers), w.PackName, len(w.Stickers))
+			continue
+		}
+		for i := range w.Stickers {
+			if g.Stickers[i].Sticker.FileID != w.Stickers[i].Sticker.FileID {
+				t.Errorf("session %d sticker #%d = %q, want %q",
+					key, i+1, g.Stickers[i].Sticker.FileID, w.Stickers[i].Sticker.FileID)
+			}
+		}
+	}
+}
+
+func TestFileStoreReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	store := openStore(t, dir)
+	cats := testSession("cats", "c1", "c2")
+	if err := store.Save(1, cats); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(2, testSession("dogs", "d1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second run changes the snapshot state only in the log and stops
+	// without closing the store, like after a crash
+	store = openStore(t, dir)
+	cats.Stickers = cats.Stickers[:1]
+	birds := testSession("birds", "b1", "b2", "b3")
+	for _, err := range []error{
+		store.Save(1, cats),
+		store.Delete(2),
+		store.Save(3, birds),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.log.Close()
+
+	store = openStore(t, dir)
+	defer store.Close()
+	checkSessions(t, store, map[int64]*UserSession{1: cats, 3: birds})
+}
+
+func TestFileStoreCompaction(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, logFile)
+
+	store := openStore(t, dir)
+	defer store.Close()
+
+	session := testSession("cats")
+	for range compactThreshold - 1 {
+		if err := store.Save(1, session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines != compactThreshold-1 {
+		t.Fatalf("log has %d records, want %d", lines, compactThreshold-1)
+	}
+
+	session.Stickers = testSession("", "c1").Stickers
+	if err := store.Save(1, session); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(logPath); err != nil || info.Size() != 0 {
+		t.Fatalf("log after %d records: %v, %v, want it empty", compactThreshold, info, err)
+	}
+
+	// The snapshot alone holds the last save
+	store.log.Close()
+	reopened := openStore(t, dir)
+	defer reopened.Close()
+	checkSessions(t, reopened, map[int64]*UserSession{1: session})
+}
+
+func TestFileStoreTruncatedLog(t *testing.T) {
+	dir := t.TempDir()
+
+	store := openStore(t, dir)
+	cats := testSession("cats", "c1")
+	if err := store.Save(1, cats); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(2, testSession("dogs", "d1")); err != nil {
+		t.Fatal(err)
+	}
+	store.log.Close()
+
+	// Cut the last record in half, as a crash during the write would
+	logPath := filepath.Join(dir, logFile)
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := bytes.LastIndexByte(data[:len(data)-1], '\n') + 1
+	if err := os.WriteFile(logPath, data[:last+(len(data)-last)/2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store = openStore(t, dir)
+	defer store.Close()
+	checkSessions(t, store, map[int64]*UserSession{1: cats})
+}
@@ -0,0 +1,114 @@
+package telegramstickers
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// validateStickerFile checks an animated or video sticker file against the
+// limits of a pack of the given type before it is uploaded.
+func validateStickerFile(data []byte, format, stickerType string) error {
+	side := stickerSide
+	if stickerType == telego.StickerTypeCustomEmoji {
+		side = customEmojiSide
+	}
+
+	switch format {
+	case telego.StickerAnimated:
+		return validateTGS(data, side)
+	case telego.StickerVideo:
+		return validateWEBM(data, side, stickerType == telego.StickerTypeCustomEmoji)
+	}
+	return nil
+}
+
+// isStickerFile reports whether the message carries an animated or video
+// sticker file.
+func isStickerFile(_ context.Context, update telego.Update) bool {
+	if update.Message == nil || update.Message.Document == nil {
+		return false
+	}
+	format := importFormat(update.Message.Document.FileName)
+	return format == telego.StickerAnimated || format == telego.StickerVideo
+}
+
+// handleStickerFile checks an animated or video sticker file and adds it to the
+// session, listing everything that has to be fixed if it breaks the limits.
+func (b *Bot) handleStickerFile(ctx context.Context, message telego.Message) {
+	key, session := b.sessionFor(message)
+	document := message.Document
+	format := importFormat(document.FileName)
+
+	source := StickerSource{UniqueID: document.FileUniqueID}
+	if pos := session.duplicateOf(source); pos > 0 {
+		b.replyDuplicate(ctx, message, pos)
+		return
+	}
+
+	if document.FileSize > maxDownloadSize {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"This file is too big, the limit is 20 MB.",
+			))
+		return
+	}
+
+	data, err := b.downloadFile(ctx, document.FileID)
+	if err != nil {
+		log.Printf("Error downloading sticker file: %v", err)
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"Failed to download the file. Please try again.",
+			))
+		return
+	}
+
+	// A copy of a file imported or uploaded before has another unique ID
+	source.Digest = contentDigest(data)
+	if pos := session.duplicateOf(source); pos > 0 {
+		b.replyDuplicate(ctx, message, pos)
+		return
+	}
+
+	if err := validateStickerFile(data, format, session.stickerType()); err != nil {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				fmt.Sprintf("Can't add %s: %v", document.FileName, err),
+			))
+		return
+	}
+
+	fileID, err := b.uploadStickerFile(ctx, message.From.ID, data, format)
+	if err != nil {
+		log.Printf("Error uploading sticker file: %v", err)
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				fmt.Sprintf("Telegram rejected %s: %v", document.FileName, err),
+			))
+		return
+	}
+
+	inputSticker := telego.InputSticker{
+		Sticker:   telego.InputFile{FileID: fileID},
+		EmojiList: []string{defaultEmoji},
+		Format:    format,
+	}
+
+	if !b.addSticker(ctx, message, key, session, inputSticker, source) {
+		return
+	}
+
+	b.promptEmoji(ctx, message.Chat.ID, session, "File checked and added!")
+}
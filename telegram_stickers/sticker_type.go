@@ -0,0 +1,231 @@
+package telegramstickers
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+)
+
+// Custom emoji must be exactly 100x100
+const customEmojiSide = 100
+
+var maskPoints = []string{"forehead", "eyes", "mouth", "chin"}
+
+var stickerTypeNames = map[string]string{
+	telego.StickerTypeRegular:     "regular stickers",
+	telego.StickerTypeMask:        "masks",
+	telego.StickerTypeCustomEmoji: "custom emoji",
+}
+
+// stickerType returns the type of pack the session will create.
+func (s *UserSession) stickerType() string {
+	if s.StickerType == "" {
+		return telego.StickerTypeRegular
+	}
+	return s.StickerType
+}
+
+// acceptType checks that a sticker of type t can join the session. The
+// first sticker of a session without an explicit type decides it.
+func (s *UserSession) acceptType(t string) error {
+	if t == "" {
+		t = telego.StickerTypeRegular
+	}
+
+	if s.StickerType == "" && len(s.Stickers) == 0 {
+		s.StickerType = t
+		return nil
+	}
+
+	if t != s.stickerType() {
+		return fmt.Errorf("this is one of the %s, but your session is making a pack of %s. "+
+			"A pack can't mix them, use /clear or /type to start a pack of %s",
+			stickerTypeNames[t], stickerTypeNames[s.stickerType()], stickerTypeNames[t])
+	}
+	return nil
+}
+
+// prepareForType drops the fields Telegram rejects for the given set type.
+func prepareForType(stickers []telego.InputSticker, stickerType string) []telego.InputSticker {
+	ret := make([]telego.InputSticker, len(stickers))
+	for i, sticker := range stickers {
+		if stickerType == telego.StickerTypeMask {
+			sticker.Keywords = nil
+		} else {
+			sticker.MaskPosition = nil
+		}
+		ret[i] = sticker
+	}
+	return ret
+}
+
+// handleType selects the type of pack the session will create.
+func (b *Bot) handleType(ctx context.Context, message telego.Message) {
+	key, session := b.sessionFor(message)
+	if !b.ownerOnly(ctx, message.Chat.ID, session, message.From.ID) || b.jobPending(ctx, message.Chat.ID, session) {
+		return
+	}
+
+	_, _, args := tu.ParseCommand(message.Text)
+	if len(args) == 0 || stickerTypeNames[args[0]] == "" {
+		repaint := ""
+		if session.NeedsRepainting {
+			repaint = ", repainted"
+		}
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"Usage: /type <regular|mask|custom_emoji> [repaint]\n\n"+
+					"regular - normal stickers\n"+
+					"mask - stickers placed on faces in photos, see /mask\n"+
+					"custom_emoji - 100x100 emoji, add \"repaint\" to tint them with the text color\n\n"+
+					fmt.Sprintf("Current type: %s%s", session.stickerType(), repaint),
+			))
+		return
+	}
+
+	stickerType := args[0]
+	if len(session.Stickers) > 0 && stickerType != session.stickerType() {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				fmt.Sprintf("Your session already has %d of the %s, which can't go into a pack of %s.\n\n"+
+					"Use /clear to start over.",
+					len(session.Stickers), stickerTypeNames[session.stickerType()], stickerTypeNames[stickerType]),
+			))
+		return
+	}
+
+	session.StickerType = stickerType
+	session.NeedsRepainting = stickerType == telego.StickerTypeCustomEmoji &&
+		len(args) > 1 && args[1] == "repaint"
+	b.saveSession(key, session)
+
+	text := "Session type set to " + stickerTypeNames[stickerType]
+	switch stickerType {
+	case telego.StickerTypeCustomEmoji:
+		text += fmt.Sprintf(".\n\nImages will be converted to %dx%d.", customEmojiSide, customEmojiSide)
+		if session.NeedsRepainting {
+			text += " Emoji will be repainted to the text color."
+		}
+	case telego.StickerTypeMask:
+		text += ".\n\nUse /mask to set where each mask goes on the face."
+	}
+
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(message.Chat.ID),
+			text,
+		))
+}
+
+// parseMaskPosition reads "<point> [x_shift y_shift scale]".
+func parseMaskPosition(args []string) (*telego.MaskPosition, error) {
+	if len(args) == 0 || !slices.Contains(maskPoints, args[0]) {
+		return nil, fmt.Errorf("point must be one of %s", strings.Join(maskPoints, ", "))
+	}
+
+	position := &telego.MaskPosition{Point: args[0], Scale: 1}
+	if len(args) == 1 {
+		return position, nil
+	}
+	if len(args) != 4 {
+		return nil, fmt.Errorf("give either just the point or the point with x shift, y shift and scale")
+	}
+
+	values := make([]float64, 3)
+	for i, arg := range args[1:] {
+		v, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", arg)
+		}
+		values[i] = v
+	}
+	if values[2] <= 0 {
+		return nil, fmt.Errorf("scale must be positive")
+	}
+
+	position.XShift, position.YShift, position.Scale = values[0], values[1], values[2]
+	return position, nil
+}
+
+// handleMask sets the mask position of one or all stickers.
+func (b *Bot) handleMask(ctx context.Context, message telego.Message) {
+	key, session := b.sessionFor(message)
+	if !b.ownerOnly(ctx, message.Chat.ID, session, message.From.ID) || b.jobPending(ctx, message.Chat.ID, session) {
+		return
+	}
+
+	if session.stickerType() != telego.StickerTypeMask {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"Mask positions are only used in mask packs, see /type.",
+			))
+		return
+	}
+
+	_, _, args := tu.ParseCommand(message.Text)
+	usage := "Usage: /mask <number|all> <forehead|eyes|mouth|chin> [x_shift y_shift scale]\n\n" +
+		"Shifts are in mask widths and heights, e.g. /mask 1 eyes 0 -0.2 1.5"
+	if len(args) < 2 {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				usage,
+			))
+		return
+	}
+
+	from, to := 1, len(session.Stickers)
+	if args[0] != "all" {
+		pos, err := strconv.Atoi(args[0])
+		if err != nil || pos < 1 || pos > len(session.Stickers) {
+			_, _ = b.api.SendMessage(
+				ctx,
+				tu.Message(
+					tu.ID(message.Chat.ID),
+					fmt.Sprintf("There is no sticker #%s, you have %d.", args[0], len(session.Stickers)),
+				))
+			return
+		}
+		from, to = pos, pos
+	}
+
+	position, err := parseMaskPosition(args[1:])
+	if err != nil {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				fmt.Sprintf("Invalid mask position: %v\n\n%s", err, usage),
+			))
+		return
+	}
+
+	for i := from - 1; i < to; i++ {
+		p := *position
+		session.Stickers[i].MaskPosition = &p
+	}
+	// Undoing a removal would bring back the old position
+	session.forgetHistory()
+	b.saveSession(key, session)
+
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(message.Chat.ID),
+			fmt.Sprintf("Mask position of %d sticker(s) set to %s (x %.2f, y %.2f, scale %.2f)",
+				to-from+1, position.Point, position.XShift, position.YShift, position.Scale),
+		))
+}
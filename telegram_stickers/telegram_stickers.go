@@ -3,8 +3,15 @@ package telegramstickers
 import (
 	"context"
 	"fmt"
+	"html"
 	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/mymmrac/telego"
 	th "github.com/mymmrac/telego/telegohandler"
@@ -13,28 +20,105 @@ import (
 )
 
 type UserSession struct {
-	Stickers  []telego.InputSticker
+	Stickers []telego.InputSticker
+
+	// Where each of Stickers came from, used to catch duplicates
+	Sources []StickerSource
+
 	PackName  string
 	PackTitle string
+
+	// Type of the pack, one of the telego.StickerType* values, empty until chosen
+	StickerType     string
+	NeedsRepainting bool
+
+	// Pack creation in progress, kept until every sticker is uploaded
+	Job *PackJob
+
+	// Set for sessions shared by a group chat: the member who approves
+	// contributions and owns the packs created from them
+	OwnerID   int64
+	OwnerName string
+
+	// Contributions of other group members waiting for the owner
+	Pending       []PendingSticker
+	NextPendingID int
+
+	// When the session was last used, idle sessions expire
+	LastUsed time.Time
+	warned   bool
+
+	// Set once the session was evicted, expired or cleared, a handler still
+	// holding it must not save it back
+	dropped bool
+
+	// Edits /undo and /redo step through, newest last, and the steps of the
+	// edit being made. Kept in memory only, a restart starts a new history
+	history []sessionEdit
+	undone  []sessionEdit
+	editing []editStep
+
+	// Position (1-based) of the sticker waiting for emoji, 0 if none
+	awaitingEmoji int
+
+	// Image waiting for "add anyway" because it looks like a session sticker
+	held *heldSticker
 }
 
 type Bot struct {
 	api      *telego.Bot
 	sessions map[int64]*UserSession
+	store    SessionStore
+	packs    *PackRegistry
+	limits   SessionLimits
 	mu       sync.RWMutex
 	ctx      context.Context
 	cancel   context.CancelFunc
+
+	// Pack each user picked in /mypacks for the management commands, kept
+	// out of the sessions so managing packs doesn't start one. Guarded by mu
+	managed map[int64]string
+
+	// Locks of the sessions handlers are working on, guarded by mu
+	locks map[int64]*sessionLock
+
+	// Background workers and running handlers, waited for on Close. Once
+	// closed is set, guarded by mu, no new handler starts
+	wg     sync.WaitGroup
+	closed bool
 }
 
-func NewBot(token string) (*Bot, error) {
+func NewBot(token string, store SessionStore, packs *PackRegistry, limits SessionLimits) (*Bot, error) {
 	api, err := telego.NewBot(token, telego.WithDefaultDebugLogger())
 	if err != nil {
 		return nil, err
 	}
 
+	sessions, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("load sessions: %w", err)
+	}
+	log.Printf("Restored %d session(s)", len(sessions))
+
+	// Sessions saved before expiry existed start their idle time now
+	now := time.Now()
+	for _, session := range sessions {
+		if session.LastUsed.IsZero() {
+			session.LastUsed = now
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Bot{
 		api:      api,
-		sessions: make(map[int64]*UserSession),
+		sessions: sessions,
+		store:    store,
+		packs:    packs,
+		limits:   limits,
+		managed:  make(map[int64]string),
+		locks:    make(map[int64]*sessionLock),
+		ctx:      ctx,
+		cancel:   cancel,
 	}, nil
 }
 
@@ -43,17 +127,127 @@ func (b *Bot) getSession(userID int64) *UserSession {
 	defer b.mu.Unlock()
 
 	if b.sessions[userID] == nil {
+		if notices := b.evictLocked(); len(notices) > 0 {
+			go b.sendNotices(b.ctx, notices)
+		}
 		b.sessions[userID] = &UserSession{
 			Stickers: make([]telego.InputSticker, 0),
 		}
 	}
-	return b.sessions[userID]
+	session := b.sessions[userID]
+	session.touch(time.Now())
+	return session
+}
+
+// findSession returns the session of userID if there is one. Unlike
+// getSession it doesn't start a new one.
+func (b *Bot) findSession(userID int64) *UserSession {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	session := b.sessions[userID]
+	if session != nil {
+		session.touch(time.Now())
+	}
+	return session
+}
+
+// sessionLock serializes the handlers working on one session.
+type sessionLock struct {
+	sync.Mutex
+
+	// Handlers holding or waiting for the lock, guarded by Bot.mu
+	refs int
+}
+
+// lockSessions locks the sessions of keys, whether they exist yet or not,
+// and returns the function that unlocks them. Keys are locked in ascending
+// order so two handlers never wait for each other.
+func (b *Bot) lockSessions(keys ...int64) (unlock func()) {
+	keys = slices.Compact(slices.Sorted(slices.Values(keys)))
+
+	b.mu.Lock()
+	locks := make([]*sessionLock, 0, len(keys))
+	for _, key := range keys {
+		lock := b.locks[key]
+		if lock == nil {
+			lock = &sessionLock{}
+			b.locks[key] = lock
+		}
+		lock.refs++
+		locks = append(locks, lock)
+	}
+	b.mu.Unlock()
+
+	for _, lock := range locks {
+		lock.Lock()
+	}
+
+	return func() {
+		for _, lock := range locks {
+			lock.Unlock()
+		}
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, lock := range locks {
+			if lock.refs--; lock.refs == 0 {
+				delete(b.locks, keys[i])
+			}
+		}
+	}
+}
+
+// sessionBusyLocked reports whether a handler holds or waits for the lock
+// of the session. The caller must hold b.mu.
+func (b *Bot) sessionBusyLocked(key int64) bool {
+	return b.locks[key] != nil
+}
+
+// updateSessionKeys returns the keys of the sessions an update may work on:
+// the sender's own and, in a group chat, the chat's.
+func updateSessionKeys(update telego.Update) []int64 {
+	var chat telego.Chat
+	var from *telego.User
+	switch {
+	case update.Message != nil:
+		chat, from = update.Message.Chat, update.Message.From
+	case update.CallbackQuery != nil:
+		from = &update.CallbackQuery.From
+		if update.CallbackQuery.Message != nil {
+			chat = update.CallbackQuery.Message.GetChat()
+		}
+	}
+	if from == nil {
+		return nil
+	}
+
+	if isGroupChat(chat) {
+		return []int64{chat.ID, from.ID}
+	}
+	return []int64{from.ID}
+}
+
+// saveSession persists the session after it was modified.
+// Everything changed since the last save becomes one edit for /undo.
+func (b *Bot) saveSession(userID int64, session *UserSession) {
+	session.commitEdit()
+	if session.dropped {
+		return
+	}
+	if err := b.store.Save(userID, session); err != nil {
+		log.Printf("Error saving session %d: %v", userID, err)
+	}
 }
 
 func (b *Bot) clearSession(userID int64) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	delete(b.sessions, userID)
+	b.dropSessionLocked(userID)
+
+	if err := b.store.Delete(userID); err != nil {
+		log.Printf("Error deleting session %d: %v", userID, err)
+	}
 }
 
 func (b *Bot) handleStart(ctx context.Context, message telego.Message) error {
@@ -65,19 +259,34 @@ func (b *Bot) handleStart(ctx context.Context, message telego.Message) error {
 				"<b>Commands:</b>\n"+
 				"/start - Show this message\n"+
 				"/add - Start adding stickers\n"+
-				"/list - View your current stickers\n"+
+				"/list - View and edit your current stickers\n"+
+				"/emoji - Set emoji for all stickers\n"+
+				"/title - Set the pack title\n"+
+				"/name - Set the pack link name\n"+
 				"/create - Create sticker pack\n"+
-				"/clear - Clear all stickers\n\n"+
+				"/addto - Add stickers to an existing pack\n"+
+				"/clone - Copy a sticker set into your session\n"+
+				"/export - Download your session or a pack as a zip\n"+
+				"/type - Make regular stickers, masks or custom emoji\n"+
+				"/mask - Set where masks go on the face\n"+
+				"/text - Make a sticker from text, or a quote of a message\n"+
+				"/undo - Take back the last change to your stickers\n"+
+				"/redo - Make an undone change again\n"+
+				"/clear - Clear all stickers\n"+
+				"/mypacks - Manage the packs you created\n"+
+				"/group - Build a pack together in a group chat\n\n"+
 				"<b>Usage:</b>\n"+
 				"1. Send /add to start\n"+
-				"2. Send stickers one by one\n"+
-				"3. Send /create to make your pack",
+				"2. Send stickers, photos or image files one by one, or a zip of them\n"+
+				"3. Reply with emoji and keywords for each sticker\n"+
+				"4. Send /create to make your pack\n\n"+
+				"Type my name and a keyword or emoji in any chat to send stickers from your packs.",
 		).WithParseMode("HTML"))
 	return nil
 }
 
 func (b *Bot) handleAdd(ctx context.Context, message telego.Message) {
-	session := b.getSession(message.From.ID)
+	_, session := b.sessionFor(message)
 
 	_, _ = b.api.SendMessage(
 		ctx,
@@ -90,12 +299,8 @@ func (b *Bot) handleAdd(ctx context.Context, message telego.Message) {
 	)
 }
 
-func (b *Bot) handleSticker(ctx context.Context, message telego.Message) {
-	session := b.getSession(message.From.ID)
-
-	// Get the sticker file
-	sticker := message.Sticker
-
+// inputFromSticker makes an input sticker for a new pack out of an existing sticker.
+func inputFromSticker(sticker telego.Sticker) telego.InputSticker {
 	// Determine format based on sticker properties
 	format := "static"
 	if sticker.IsAnimated {
@@ -104,48 +309,245 @@ func (b *Bot) handleSticker(ctx context.Context, message telego.Message) {
 		format = "video"
 	}
 
+	// Start from the emoji of the original sticker
+	emoji := sticker.Emoji
+	if emoji == "" {
+		emoji = defaultEmoji
+	}
+
+	return telego.InputSticker{
+		Sticker:      telego.InputFile{FileID: sticker.FileID},
+		EmojiList:    []string{emoji},
+		Format:       format,
+		MaskPosition: sticker.MaskPosition,
+	}
+}
+
+func (b *Bot) handleSticker(ctx context.Context, message telego.Message) {
+	key, session := b.sessionFor(message)
+
+	if err := session.acceptType(message.Sticker.Type); err != nil {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				fmt.Sprintf("Can't add this sticker: %v", err),
+			))
+		return
+	}
+
+	source := StickerSource{UniqueID: message.Sticker.FileUniqueID}
+	if pos := session.duplicateOf(source); pos > 0 {
+		b.replyDuplicate(ctx, message, pos)
+		return
+	}
+
 	// Create input sticker for the new pack
-	inputSticker := telego.InputSticker{
-		Sticker:   telego.InputFile{FileID: sticker.FileID},
-		EmojiList: []string{"😀"}, // Default emoji, can be customized
-		Format:    format,
+	inputSticker := inputFromSticker(*message.Sticker)
+
+	if !b.addSticker(ctx, message, key, session, inputSticker, source) {
+		return
 	}
 
-	session.Stickers = append(session.Stickers, inputSticker)
+	b.promptEmoji(ctx, message.Chat.ID, session, "Sticker added!")
+}
+
+func (b *Bot) handleCreate(ctx context.Context, message telego.Message) {
+	key, session := b.sessionFor(message)
+	if !b.ownerOnly(ctx, message.Chat.ID, session, message.From.ID) {
+		return
+	}
+
+	if session.Job != nil {
+		// A previous attempt failed, continue where it stopped
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				fmt.Sprintf("Resuming your sticker pack from sticker #%d of %d...",
+					session.Job.Done+1, len(session.Job.Stickers)),
+			),
+		)
+		b.runPackJob(ctx, message, key, session)
+		return
+	}
+
+	if len(session.Stickers) == 0 {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"You need to add at least one sticker first.\n\nUse /add and send stickers.",
+			))
+		return
+	}
+
+	// Telegram rejects the whole set if any sticker has a bad emoji list
+	for i, sticker := range session.Stickers {
+		if err := validateEmoji(sticker.EmojiList); err != nil {
+			_, _ = b.api.SendMessage(
+				ctx,
+				tu.Message(
+					tu.ID(message.Chat.ID),
+					fmt.Sprintf("Sticker #%d: %v\n\nUse /emoji to fix it.", i+1, err),
+				))
+			return
+		}
+	}
+
+	// Get bot username for pack name
+	botUser, err := b.api.GetMe(ctx)
+	if err != nil {
+		log.Printf("Error getting bot info: %v", err)
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"Failed to get bot information. Please try again.",
+			))
+		return
+	}
+
+	// Use the chosen name and title, or generate them
+	packName := session.PackName
+	if packName == "" {
+		packName = fmt.Sprintf("pack_%d_%d_by_%s", message.From.ID, message.Date, botUser.Username)
+	}
+	packTitle := session.PackTitle
+	if packTitle == "" {
+		packTitle = fmt.Sprintf("%s's Custom Pack", message.From.FirstName)
+	}
 
+	job := newPackJob(session, packName, packTitle)
+	if !b.checkPartNames(ctx, message.Chat.ID, job) {
+		return
+	}
+	session.Job = job
+	b.saveSession(key, session)
+
+	text := "Creating your sticker pack..."
+	if parts := session.Job.partCount(); parts > 1 {
+		text = fmt.Sprintf("A pack holds at most %d stickers, creating %d packs for your %d stickers...",
+			setCapacity(session.Job.StickerType), parts, len(session.Job.Stickers))
+	}
 	_, _ = b.api.SendMessage(
 		ctx,
 		tu.Message(
 			tu.ID(message.Chat.ID),
-			fmt.Sprintf("Sticker added! Total: %d\n\n"+
-				"Send more stickers or use /create to make your pack.", len(session.Stickers)),
+			text,
 		),
 	)
+
+	b.runPackJob(ctx, message, key, session)
 }
 
-func (b *Bot) handleList(ctx context.Context, message telego.Message) {
-	session := b.getSession(message.From.ID)
+// checkPartNames makes sure every pack of the job can be created before the
+// first upload, so a job isn't left with only some of its parts. It tells
+// the user what's wrong otherwise.
+func (b *Bot) checkPartNames(ctx context.Context, chatID int64, job *PackJob) bool {
+	for p := 0; p < job.partCount(); p++ {
+		name := job.partName(p)
+		var text string
+		if err := validatePackName(name); err != nil {
+			text = fmt.Sprintf("Can't create pack <code>%s</code>: %v\n\nUse /name to pick another name.",
+				html.EscapeString(name), err)
+		} else if available, err := b.packNameAvailable(ctx, name); err != nil {
+			log.Printf("Error checking pack name: %v", err)
+			text = "Failed to check the pack name. Please try again."
+		} else if !available {
+			text = fmt.Sprintf("Name <code>%s</code> is already taken.\n\nUse /name to pick another one.",
+				html.EscapeString(name))
+		} else {
+			continue
+		}
 
-	if len(session.Stickers) == 0 {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(chatID),
+				text,
+			).WithParseMode("HTML"))
+		return false
+	}
+	return true
+}
+
+// runPackJob uploads the session job for the message author, saving
+// progress after every sticker.
+func (b *Bot) runPackJob(ctx context.Context, message telego.Message, key int64, session *UserSession) {
+	job := session.Job
+
+	created := job.Created
+	err := job.run(ctx, b.api, message.From.ID, func() {
+		// Register new parts at once with the stickers they start with, a
+		// job that fails later still leaves them owned by the user for
+		// /mypacks, /addto and inline search
+		for ; created < job.Created; created++ {
+			start := created * setCapacity(job.StickerType)
+			stickers := knownStickers(job.Stickers[start:min(job.Done, start+job.partSize(created))])
+			err := b.packs.Record(message.From.ID, job.partName(created), job.partTitle(created), job.StickerType, stickers)
+			if err != nil {
+				log.Printf("Error registering pack %s: %v", job.partName(created), err)
+			}
+		}
+		b.saveSession(key, session)
+	})
+	if err != nil {
+		log.Printf("Error creating sticker set: %v", err)
 		_, _ = b.api.SendMessage(
 			ctx,
 			tu.Message(
 				tu.ID(message.Chat.ID),
-				"You haven't added any stickers yet.\n\nUse /add and send stickers to begin.",
+				fmt.Sprintf("Failed to create sticker pack: %v\n\n"+
+					"%d of %d sticker(s) are uploaded and your progress is saved. "+
+					"Use /create to continue or /clear to cancel.", err, job.Done, len(job.Stickers)),
 			))
 		return
 	}
 
+	for p := 0; p < job.Created; p++ {
+		start := p * setCapacity(job.StickerType)
+		b.indexPack(ctx, message.From.ID, job.partName(p), job.Stickers[start:start+job.partSize(p)])
+	}
+
+	// Clear session after successful creation
+	b.clearSession(key)
+
+	var text strings.Builder
+	text.WriteString("<b>Sticker pack created successfully!</b>\n\n")
+	for p := 0; p < job.Created; p++ {
+		fmt.Fprintf(&text, "Pack name: <code>%s</code>\n"+
+			"Title: %s\n"+
+			"You can find it here: https://t.me/addstickers/%s\n\n",
+			job.partName(p), html.EscapeString(job.partTitle(p)), job.partName(p))
+	}
+
 	_, _ = b.api.SendMessage(
 		ctx,
 		tu.Message(
 			tu.ID(message.Chat.ID),
-			fmt.Sprintf("📋 You have %d sticker(s) ready.\n\nUse /create to make your pack!", len(session.Stickers)),
-		))
+			text.String(),
+		).WithParseMode("HTML"))
 }
 
-func (b *Bot) handleCreate(ctx context.Context, message telego.Message) {
-	session := b.getSession(message.From.ID)
+// handleAddTo adds the session stickers to a pack made earlier by this bot.
+func (b *Bot) handleAddTo(ctx context.Context, message telego.Message) {
+	key, session := b.sessionFor(message)
+	if !b.ownerOnly(ctx, message.Chat.ID, session, message.From.ID) {
+		return
+	}
+
+	_, _, args := tu.ParseCommand(message.Text)
+	if len(args) != 1 {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"Usage: /addto <pack_name>\n\nAdds your current stickers to a pack you created with this bot.",
+			))
+		return
+	}
+	packName := strings.TrimPrefix(args[0], "https://t.me/addstickers/")
 
 	if len(session.Stickers) == 0 {
 		_, _ = b.api.SendMessage(
@@ -157,7 +559,18 @@ func (b *Bot) handleCreate(ctx context.Context, message telego.Message) {
 		return
 	}
 
-	// Get bot username for pack name
+	for i, sticker := range session.Stickers {
+		if err := validateEmoji(sticker.EmojiList); err != nil {
+			_, _ = b.api.SendMessage(
+				ctx,
+				tu.Message(
+					tu.ID(message.Chat.ID),
+					fmt.Sprintf("Sticker #%d: %v\n\nUse /emoji to fix it.", i+1, err),
+				))
+			return
+		}
+	}
+
 	botUser, err := b.api.GetMe(ctx)
 	if err != nil {
 		log.Printf("Error getting bot info: %v", err)
@@ -170,56 +583,136 @@ func (b *Bot) handleCreate(ctx context.Context, message telego.Message) {
 		return
 	}
 
-	// Generate unique pack name
-	packName := fmt.Sprintf("pack_%d_%d_by_%s", message.From.ID, message.Date, botUser.Username)
-	packTitle := fmt.Sprintf("%s's Custom Pack", message.From.FirstName)
-
-	_, _ = b.api.SendMessage(
-		ctx,
-		tu.Message(
-			tu.ID(message.Chat.ID),
-			"Creating your sticker pack...",
-		),
-	)
+	// Bots can only modify sets they created, and those always end with the bot name
+	if !strings.HasSuffix(strings.ToLower(packName), "_by_"+strings.ToLower(botUser.Username)) {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				fmt.Sprintf("Pack <code>%s</code> wasn't created by this bot, I can only add to packs ending with <code>_by_%s</code>.",
+					html.EscapeString(packName), botUser.Username),
+			).WithParseMode("HTML"))
+		return
+	}
 
-	// Create new sticker set
-	params := &telego.CreateNewStickerSetParams{
-		UserID:      message.From.ID,
-		Name:        packName,
-		Title:       packTitle,
-		Stickers:    session.Stickers,
-		StickerType: "regular",
+	// Packs made before the registry existed are left to Telegram's owner check
+	owner, known := b.packs.Owner(packName)
+	if known && owner != message.From.ID {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"This pack belongs to someone else, see /mypacks for yours.",
+			))
+		return
 	}
 
-	err = b.api.CreateNewStickerSet(ctx, params)
+	set, err := b.api.GetStickerSet(ctx, &telego.GetStickerSetParams{Name: packName})
 	if err != nil {
-		log.Printf("Error creating sticker set: %v", err)
+		log.Printf("Error getting sticker set %s: %v", packName, err)
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				fmt.Sprintf("Pack <code>%s</code> not found.", html.EscapeString(packName)),
+			).WithParseMode("HTML"))
+		return
+	}
+
+	if set.StickerType != session.stickerType() {
 		_, _ = b.api.SendMessage(
 			ctx,
 			tu.Message(
 				tu.ID(message.Chat.ID),
-				fmt.Sprintf("Failed to create sticker pack: %v\n\nPlease try again.", err),
+				fmt.Sprintf("%s is a pack of %s, but your session has %s.",
+					set.Title, stickerTypeNames[set.StickerType], stickerTypeNames[session.stickerType()]),
 			))
 		return
 	}
 
-	// Clear session after successful creation
-	b.clearSession(message.From.ID)
+	text := fmt.Sprintf("Adding %d sticker(s) to %s...", len(session.Stickers), set.Title)
+	if !known {
+		text += "\n\nI have no record of who made this pack, Telegram will refuse the stickers unless it was you."
+	}
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(message.Chat.ID),
+			text,
+		),
+	)
+
+	// Telegram only accepts the owner's user ID, which is what makes the
+	// pack belong to this user
+	var report strings.Builder
+	failed := make([]telego.InputSticker, 0)
+	failedSources := make([]StickerSource, 0)
+	succeeded := make([]telego.InputSticker, 0)
+	session.alignSources()
+	for i, sticker := range session.Stickers {
+		err := b.api.AddStickerToSet(ctx, &telego.AddStickerToSetParams{
+			UserID:  message.From.ID,
+			Name:    set.Name,
+			Sticker: prepareForType([]telego.InputSticker{sticker}, set.StickerType)[0],
+		})
+		if err != nil {
+			log.Printf("Error adding sticker to set %s: %v", set.Name, err)
+			fmt.Fprintf(&report, "#%d ❌ %s\n", i+1, html.EscapeString(err.Error()))
+			failed = append(failed, sticker)
+			failedSources = append(failedSources, session.Sources[i])
+			continue
+		}
+		fmt.Fprintf(&report, "#%d ✅\n", i+1)
+		succeeded = append(succeeded, sticker)
+	}
+
+	added := len(session.Stickers) - len(failed)
+	if added > 0 {
+		// Telegram accepted the user as the owner
+		b.indexPack(ctx, message.From.ID, set.Name, succeeded)
+	}
+	// A half created pack and the group stay, only what was uploaded leaves
+	if len(failed) == 0 && session.Job == nil && !session.isGroup() {
+		b.clearSession(key)
+	} else {
+		// Keep only what failed so it can be retried
+		session.Stickers = failed
+		session.Sources = failedSources
+		session.forgetHistory()
+		session.awaitingEmoji = 0
+		b.saveSession(key, session)
+	}
+
+	summary := fmt.Sprintf("<b>Added %d of %d sticker(s) to %s</b>\n\n%s\n",
+		added, added+len(failed), html.EscapeString(set.Title), report.String())
+	if len(failed) > 0 {
+		summary += "Failed stickers are still in your session, fix them and run /addto again.\n\n"
+	}
+	summary += "https://t.me/addstickers/" + set.Name
 
 	_, _ = b.api.SendMessage(
 		ctx,
 		tu.Message(
 			tu.ID(message.Chat.ID),
-			fmt.Sprintf("<b>Sticker pack created successfully!</b>\n\n"+
-				"Pack name: <code>%s</code>\n"+
-				"Title: %s\n\n"+
-				"You can find it here: https://t.me/addstickers/%s",
-				packName, packTitle, packName),
+			summary,
 		).WithParseMode("HTML"))
 }
 
 func (b *Bot) handleClear(ctx context.Context, message telego.Message) {
-	b.clearSession(message.From.ID)
+	key, session := b.sessionFor(message)
+	if !b.ownerOnly(ctx, message.Chat.ID, session, message.From.ID) {
+		return
+	}
+	b.clearSession(key)
+
+	// A group keeps its session and owner, only the stickers go
+	if session.isGroup() {
+		group := b.getSession(key)
+		group.OwnerID = session.OwnerID
+		group.OwnerName = session.OwnerName
+		group.PackTitle = session.PackTitle
+		b.saveSession(key, group)
+	}
 
 	_, _ = b.api.SendMessage(
 		ctx,
@@ -229,9 +722,30 @@ func (b *Bot) handleClear(ctx context.Context, message telego.Message) {
 		))
 }
 
+func isSticker(_ context.Context, update telego.Update) bool {
+	return update.Message != nil && update.Message.Sticker != nil
+}
+
+func isImage(_ context.Context, update telego.Update) bool {
+	if update.Message == nil {
+		return false
+	}
+	_, _, ok := imageSource(*update.Message)
+	return ok
+}
+
+func (b *Bot) handleUnsupported(ctx context.Context, message telego.Message) {
+	_, _ = b.api.SendMessage(
+		ctx,
+		tu.Message(
+			tu.ID(message.Chat.ID),
+			"I can only use stickers, photos, PNG, JPEG, WEBP, TGS or WEBM files and zip archives of them.",
+		))
+}
+
 func (b *Bot) Run() error {
-	// Get bot user info
-	ctx := context.Background()
+	// Get bot user info, polling stops when the bot is closed
+	ctx := b.ctx
 
 	user, err := b.api.GetMe(ctx)
 	if err != nil {
@@ -247,6 +761,22 @@ func (b *Bot) Run() error {
 		return fmt.Errorf("failed to create bot handler: %w", err)
 	}
 
+	// Close waits for the handlers, they may still save sessions
+	bh.Use(func(ctx *th.Context, update telego.Update) error {
+		if !b.startHandler() {
+			return nil
+		}
+		defer b.wg.Done()
+		return ctx.Next(update)
+	})
+
+	// Telego runs every update in its own goroutine, the updates of one
+	// session are handled one after another
+	bh.Use(func(ctx *th.Context, update telego.Update) error {
+		defer b.lockSessions(updateSessionKeys(update)...)()
+		return ctx.Next(update)
+	})
+
 	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
 		// Send a message with inline keyboard
 		b.handleStart(ctx, message)
@@ -261,7 +791,7 @@ func (b *Bot) Run() error {
 
 	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
 		// Send a message with inline keyboard
-		b.handleAdd(ctx, message)
+		b.handleList(ctx, message)
 		return nil
 	}, th.CommandEqual("list"))
 
@@ -277,12 +807,170 @@ func (b *Bot) Run() error {
 		return nil
 	}, th.CommandEqual("clear"))
 
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleUndo(ctx, message)
+		return nil
+	}, th.CommandEqual("undo"))
+
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleRedo(ctx, message)
+		return nil
+	}, th.CommandEqual("redo"))
+
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleTitle(ctx, message)
+		return nil
+	}, th.CommandEqual("title"))
+
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleName(ctx, message)
+		return nil
+	}, th.CommandEqual("name"))
+
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleAddTo(ctx, message)
+		return nil
+	}, th.CommandEqual("addto"))
+
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleClone(ctx, message)
+		return nil
+	}, th.CommandEqual("clone"))
+
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleExport(ctx, message)
+		return nil
+	}, th.CommandEqual("export"))
+
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleType(ctx, message)
+		return nil
+	}, th.CommandEqual("type"))
+
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleMask(ctx, message)
+		return nil
+	}, th.CommandEqual("mask"))
+
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleMyPacks(ctx, message)
+		return nil
+	}, th.CommandEqual("mypacks"))
+
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handlePack(ctx, message)
+		return nil
+	}, th.CommandEqual("pack"))
+
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleDeleteSticker(ctx, message)
+		return nil
+	}, th.CommandEqual("delsticker"))
+
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleMoveSticker(ctx, message)
+		return nil
+	}, th.CommandEqual("move"))
+
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleSetTitle(ctx, message)
+		return nil
+	}, th.CommandEqual("settitle"))
+
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleThumb(ctx, message)
+		return nil
+	}, th.CommandEqual("thumb"))
+
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleReplace(ctx, message)
+		return nil
+	}, th.CommandEqual("replace"))
+
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleEmojiAll(ctx, message)
+		return nil
+	}, th.CommandEqual("emoji"))
+
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleText(ctx, message)
+		return nil
+	}, th.CommandEqual("text"))
+
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleGroup(ctx, message)
+		return nil
+	}, th.CommandEqual("group"))
+
 	// Handle stickers
 	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
 		b.handleSticker(ctx, message)
 		return nil
+	}, isSticker)
+
+	// Zip archives are imported entry by entry
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleImport(ctx, message)
+		return nil
+	}, isArchive)
+
+	// Animated and video sticker files are checked locally before upload
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleStickerFile(ctx, message)
+		return nil
+	}, isStickerFile)
+
+	// Photos and image files are converted into stickers
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleImage(ctx, message)
+		return nil
+	}, isImage)
+
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleUnsupported(ctx, message)
+		return nil
 	}, th.AnyMessageWithMedia())
 
+	// Plain text is the emoji reply for the last added sticker
+	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
+		b.handleEmojiReply(ctx, message)
+		return nil
+	}, th.AnyMessageWithText(), th.Not(th.AnyCommand()))
+
+	bh.HandleCallbackQuery(func(ctx *th.Context, query telego.CallbackQuery) error {
+		b.handleEmojiCallback(ctx, query)
+		return nil
+	}, th.CallbackDataPrefix("emoji:"))
+
+	bh.HandleCallbackQuery(func(ctx *th.Context, query telego.CallbackQuery) error {
+		b.handleEditorCallback(ctx, query)
+		return nil
+	}, th.CallbackDataPrefix("edit:"))
+
+	bh.HandleCallbackQuery(func(ctx *th.Context, query telego.CallbackQuery) error {
+		b.handlePackCallback(ctx, query)
+		return nil
+	}, th.CallbackDataPrefix("pack:"))
+
+	bh.HandleCallbackQuery(func(ctx *th.Context, query telego.CallbackQuery) error {
+		b.handleGroupCallback(ctx, query)
+		return nil
+	}, th.CallbackDataPrefix("group:"))
+
+	bh.HandleCallbackQuery(func(ctx *th.Context, query telego.CallbackQuery) error {
+		b.handleDuplicateCallback(ctx, query)
+		return nil
+	}, th.CallbackDataPrefix("dup:"))
+
+	// Inline search over the user's own packs
+	bh.HandleInlineQuery(func(ctx *th.Context, query telego.InlineQuery) error {
+		b.handleInlineQuery(ctx, query)
+		return nil
+	}, th.AnyInlineQuery())
+
+	b.wg.Add(1)
+	go b.janitor()
+
 	bh.Start()
 	defer func() { _ = bh.Stop() }()
 
@@ -290,18 +978,67 @@ func (b *Bot) Run() error {
 
 }
 
+// startHandler counts a handler in b.wg, it reports false once the bot is
+// closed.
+func (b *Bot) startHandler() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return false
+	}
+	b.wg.Add(1)
+	return true
+}
+
+// Close stops polling and the janitor and waits for running handlers, then
+// flushes the session store.
+func (b *Bot) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+
+	b.cancel()
+	b.wg.Wait()
+	return b.store.Close()
+}
+
 func RunStickerBot() {
 	cfg := config.GetConfig()
-	bot, err := NewBot(cfg.TelegramBotToken)
+	store, err := NewFileStore(cfg.SessionDir)
+	if err != nil {
+		log.Fatalf("Failed to open session store: %v", err)
+	}
+
+	packs, err := NewPackRegistry(cfg.PacksFile)
+	if err != nil {
+		log.Fatalf("Failed to open pack registry: %v", err)
+	}
+
+	bot, err := NewBot(cfg.TelegramBotToken, store, packs, SessionLimits{
+		TTL:         cfg.SessionTTL,
+		Warning:     cfg.SessionWarning,
+		MaxStickers: cfg.MaxSessionStickers,
+		MaxSessions: cfg.MaxSessions,
+	})
 	if err != nil {
 		log.Fatalf("Failed to create bot: %v", err)
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	log.Println("Starting bot...")
-	if err := bot.Run(); err != nil {
-		log.Fatalf("Bot error: %v", err)
-	}
+	go func() {
+		if err := bot.Run(); err != nil {
+			log.Fatalf("Bot error: %v", err)
+		}
+	}()
 
-	// Keep running
-	select {}
+	// Keep running until interrupted
+	<-ctx.Done()
+	log.Println("Stopping bot...")
+	if err := bot.Close(); err != nil {
+		log.Printf("Error closing bot: %v", err)
+	}
 }
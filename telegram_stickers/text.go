@@ -0,0 +1,427 @@
+package telegramstickers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/mymmrac/telego"
+	tu "github.com/mymmrac/telego/telegoutil"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	maxTextLength = 500
+
+	textMargin  = 16
+	textOutline = 4
+
+	// The largest size is tried first, the text shrinks until it fits
+	maxFontSize = 96
+	minFontSize = 16
+
+	quoteRadius      = 24
+	quoteNameSize    = 32
+	maxQuoteFontSize = 44
+)
+
+var textFonts = map[string][]byte{
+	"regular": goregular.TTF,
+	"bold":    gobold.TTF,
+	"italic":  goitalic.TTF,
+	"mono":    gomono.TTF,
+}
+
+var textColors = map[string]color.NRGBA{
+	"white":  {0xFF, 0xFF, 0xFF, 0xFF},
+	"black":  {0x00, 0x00, 0x00, 0xFF},
+	"red":    {0xE5, 0x39, 0x35, 0xFF},
+	"orange": {0xFB, 0x8C, 0x00, 0xFF},
+	"yellow": {0xFD, 0xD8, 0x35, 0xFF},
+	"green":  {0x43, 0xA0, 0x47, 0xFF},
+	"blue":   {0x1E, 0x88, 0xE5, 0xFF},
+	"pink":   {0xEC, 0x40, 0x7A, 0xFF},
+}
+
+// Colors for quote author names, picked by user ID like Telegram does
+var quoteNameColors = []color.NRGBA{
+	textColors["red"], textColors["orange"], textColors["yellow"],
+	textColors["green"], textColors["blue"], textColors["pink"],
+}
+
+var (
+	parsedFonts     = make(map[string]*opentype.Font)
+	parsedFontsOnce sync.Once
+	parsedFontsErr  error
+)
+
+// loadFont returns a bundled font by name, they are parsed on first use.
+func loadFont(name string) (*opentype.Font, error) {
+	parsedFontsOnce.Do(func() {
+		for fontName, ttf := range textFonts {
+			f, err := opentype.Parse(ttf)
+			if err != nil {
+				parsedFontsErr = fmt.Errorf("parse font %s: %w", fontName, err)
+				return
+			}
+			parsedFonts[fontName] = f
+		}
+	})
+	if parsedFontsErr != nil {
+		return nil, parsedFontsErr
+	}
+	return parsedFonts[name], nil
+}
+
+// textStyle is how /text renders its message.
+type textStyle struct {
+	Font  string
+	Color color.NRGBA
+}
+
+// parseTextArgs takes the leading font=... and color=... options off the
+// text of a /text command.
+func parseTextArgs(text string) (textStyle, string, error) {
+	style := textStyle{Font: "bold", Color: textColors["white"]}
+
+	for {
+		text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		word, rest, _ := strings.Cut(text, " ")
+		key, value, ok := strings.Cut(strings.ToLower(strings.TrimSpace(word)), "=")
+		if !ok || (key != "font" && key != "color") {
+			return style, text, nil
+		}
+
+		switch key {
+		case "font":
+			if _, ok := textFonts[value]; !ok {
+				return style, "", fmt.Errorf("unknown font %q, use one of %s", value, optionNames(textFonts))
+			}
+			style.Font = value
+		case "color":
+			c, ok := textColors[value]
+			if !ok {
+				return style, "", fmt.Errorf("unknown color %q, use one of %s", value, optionNames(textColors))
+			}
+			style.Color = c
+		}
+		text = rest
+	}
+}
+
+func optionNames[V any](options map[string]V) string {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return strings.Join(names, ", ")
+}
+
+// wrapText splits text into lines no wider than width, breaking words that
+// don't fit on a line of their own.
+func wrapText(face font.Face, text string, width int) []string {
+	limit := fixed.I(width)
+	lines := make([]string, 0)
+
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if font.MeasureString(face, candidate) <= limit {
+				line = candidate
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+			}
+
+			// Break a word that is wider than a whole line
+			line = ""
+			for _, r := range word {
+				if line != "" && font.MeasureString(face, line+string(r)) > limit {
+					lines = append(lines, line)
+					line = ""
+				}
+				line += string(r)
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// textBlock is text wrapped and sized to fit a box.
+type textBlock struct {
+	face   font.Face
+	lines  []string
+	height int
+}
+
+// fitText picks the largest font size up to maxSize at which the wrapped
+// text fits a width x maxHeight box.
+func fitText(f *opentype.Font, text string, width, maxHeight, maxSize int) (textBlock, error) {
+	for size := maxSize; ; size -= 4 {
+		face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: float64(size), DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return textBlock{}, fmt.Errorf("load font: %w", err)
+		}
+
+		lines := wrapText(face, text, width)
+		height := len(lines) * face.Metrics().Height.Ceil()
+		fits := height <= maxHeight && !slices.ContainsFunc(lines, func(line string) bool {
+			return font.MeasureString(face, line) > fixed.I(width)
+		})
+		if fits {
+			return textBlock{face: face, lines: lines, height: height}, nil
+		}
+		if size <= minFontSize {
+			return textBlock{}, fmt.Errorf("the text is too long to fit a sticker")
+		}
+	}
+}
+
+// drawLines draws the block with its top left corner at (x, y). Lines are
+// centered in width unless it is 0. An outline is drawn first when its
+// alpha isn't 0.
+func drawLines(dst draw.Image, block textBlock, x, y, width int, fill, outline color.NRGBA) {
+	lineHeight := block.face.Metrics().Height.Ceil()
+	ascent := block.face.Metrics().Ascent.Ceil()
+
+	for i, line := range block.lines {
+		lineX := x
+		if width > 0 {
+			lineX += (width - font.MeasureString(block.face, line).Ceil()) / 2
+		}
+		dot := fixed.P(lineX, y+i*lineHeight+ascent)
+
+		if outline.A != 0 {
+			for dy := -textOutline; dy <= textOutline; dy++ {
+				for dx := -textOutline; dx <= textOutline; dx++ {
+					if dx*dx+dy*dy > textOutline*textOutline {
+						continue
+					}
+					d := font.Drawer{Dst: dst, Src: image.NewUniform(outline), Face: block.face, Dot: dot.Add(fixed.P(dx, dy))}
+					d.DrawString(line)
+				}
+			}
+		}
+		d := font.Drawer{Dst: dst, Src: image.NewUniform(fill), Face: block.face, Dot: dot}
+		d.DrawString(line)
+	}
+}
+
+// outlineFor returns a color that stands out around fill.
+func outlineFor(fill color.NRGBA) color.NRGBA {
+	if int(fill.R)+int(fill.G)+int(fill.B) > 3*0x80 {
+		return textColors["black"]
+	}
+	return textColors["white"]
+}
+
+// renderText draws outlined text on a transparent canvas 512px wide and
+// only as tall as the text needs.
+func renderText(text string, style textStyle) (image.Image, error) {
+	f, err := loadFont(style.Font)
+	if err != nil {
+		return nil, err
+	}
+
+	inner := stickerSide - 2*textMargin
+	block, err := fitText(f, text, inner, inner, maxFontSize)
+	if err != nil {
+		return nil, err
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, stickerSide, block.height+2*textMargin))
+	drawLines(dst, block, textMargin, textMargin, inner, style.Color, outlineFor(style.Color))
+	return dst, nil
+}
+
+// fillRoundedRect fills r with c, rounding the corners.
+func fillRoundedRect(dst *image.NRGBA, r image.Rectangle, radius int, c color.NRGBA) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			// Distance into the corner square, 0 outside the corners
+			dx := max(r.Min.X+radius-x, x-(r.Max.X-radius-1), 0)
+			dy := max(r.Min.Y+radius-y, y-(r.Max.Y-radius-1), 0)
+			if dx*dx+dy*dy <= radius*radius {
+				dst.SetNRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+// renderQuote draws a chat bubble with the author's name above the text.
+func renderQuote(author, text string, nameColor color.NRGBA) (image.Image, error) {
+	nameFont, err := loadFont("bold")
+	if err != nil {
+		return nil, err
+	}
+	textFont, err := loadFont("regular")
+	if err != nil {
+		return nil, err
+	}
+
+	// The name has a fixed size, the text gets the rest of the bubble
+	inner := stickerSide - 2*textMargin - 2*quoteRadius
+	nameFace, err := opentype.NewFace(nameFont, &opentype.FaceOptions{Size: quoteNameSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("load font: %w", err)
+	}
+	name := textBlock{face: nameFace, lines: wrapText(nameFace, author, inner)[:1]}
+	name.height = nameFace.Metrics().Height.Ceil()
+
+	block, err := fitText(textFont, text, inner, inner-name.height-quoteRadius, maxQuoteFontSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// Narrow bubbles for short messages, like in the app
+	width := font.MeasureString(nameFace, name.lines[0]).Ceil()
+	for _, line := range block.lines {
+		width = max(width, font.MeasureString(block.face, line).Ceil())
+	}
+	height := name.height + block.height + 2*quoteRadius
+
+	dst := image.NewNRGBA(image.Rect(0, 0, stickerSide, height+2*textMargin))
+	bubble := image.Rect(textMargin, textMargin, textMargin+width+2*quoteRadius, textMargin+height)
+	fillRoundedRect(dst, bubble, quoteRadius, color.NRGBA{0x21, 0x21, 0x21, 0xF0})
+
+	x, y := bubble.Min.X+quoteRadius, bubble.Min.Y+quoteRadius
+	drawLines(dst, name, x, y, 0, nameColor, color.NRGBA{})
+	drawLines(dst, block, x, y+name.height, 0, textColors["white"], color.NRGBA{})
+	return dst, nil
+}
+
+// quoteSource returns the author and text of a replied-to message.
+func quoteSource(reply *telego.Message) (author string, authorID int64, text string) {
+	text = reply.Text
+	if text == "" {
+		text = reply.Caption
+	}
+
+	switch {
+	case reply.From != nil:
+		author = strings.TrimSpace(reply.From.FirstName + " " + reply.From.LastName)
+		authorID = reply.From.ID
+	case reply.SenderChat != nil:
+		author = reply.SenderChat.Title
+		authorID = reply.SenderChat.ID
+	}
+	return author, authorID, text
+}
+
+// handleText renders the message, or the replied-to message as a quote,
+// into a sticker and adds it to the session.
+func (b *Bot) handleText(ctx context.Context, message telego.Message) {
+	key, session := b.sessionFor(message)
+
+	// Take the raw text after the command so line breaks are kept
+	text := ""
+	if i := strings.IndexFunc(message.Text, unicode.IsSpace); i >= 0 {
+		text = message.Text[i:]
+	}
+	style, text, err := parseTextArgs(text)
+	if err != nil {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				err.Error(),
+			))
+		return
+	}
+	text = strings.TrimSpace(text)
+
+	var img image.Image
+	switch reply := message.ReplyToMessage; {
+	case text != "":
+		if len([]rune(text)) > maxTextLength {
+			err = fmt.Errorf("the text is longer than %d characters", maxTextLength)
+			break
+		}
+		img, err = renderText(text, style)
+	case reply != nil:
+		author, authorID, quoted := quoteSource(reply)
+		if strings.TrimSpace(quoted) == "" {
+			err = fmt.Errorf("there is no text in that message to quote")
+			break
+		}
+		if len([]rune(quoted)) > maxTextLength {
+			err = fmt.Errorf("the message is longer than %d characters", maxTextLength)
+			break
+		}
+		nameColor := quoteNameColors[uint64(authorID)%uint64(len(quoteNameColors))]
+		img, err = renderQuote(author, quoted, nameColor)
+	default:
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				fmt.Sprintf("Usage: /text [font=...] [color=...] <text>\n\n"+
+					"Reply /text to a message to turn it into a quote sticker.\n\n"+
+					"Fonts: %s\nColors: %s", optionNames(textFonts), optionNames(textColors)),
+			))
+		return
+	}
+	if err != nil {
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				fmt.Sprintf("Can't make a sticker: %v", err),
+			))
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		log.Printf("Error encoding text sticker: %v", err)
+		return
+	}
+
+	fileID, err := b.uploadImage(ctx, message.From.ID, buf.Bytes(), session.stickerType())
+	if err != nil {
+		log.Printf("Error uploading text sticker: %v", err)
+		_, _ = b.api.SendMessage(
+			ctx,
+			tu.Message(
+				tu.ID(message.Chat.ID),
+				"Failed to upload the sticker. Please try again.",
+			))
+		return
+	}
+
+	inputSticker := telego.InputSticker{
+		Sticker:   telego.InputFile{FileID: fileID},
+		EmojiList: []string{defaultEmoji},
+		Format:    telego.StickerStatic,
+	}
+
+	// Generated stickers have no source, the same text may be wanted twice
+	if !b.addSticker(ctx, message, key, session, inputSticker, StickerSource{}) {
+		return
+	}
+
+	_, _ = b.api.SendSticker(ctx, tu.Sticker(tu.ID(message.Chat.ID), inputSticker.Sticker))
+	b.promptEmoji(ctx, message.Chat.ID, session, "Text sticker added!")
+}
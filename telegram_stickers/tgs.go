@@ -0,0 +1,110 @@
+package telegramstickers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	maxAnimatedFileSize = 64 * 1024
+	animatedFrameRate   = 60
+	maxAnimatedDuration = 3.0
+
+	// Lottie JSON is small, anything bigger is not a sticker
+	maxLottieSize = 4 * 1024 * 1024
+)
+
+// stickerFileError lists every limit a sticker file breaks, so the user can
+// fix all of them at once.
+type stickerFileError struct {
+	Format     string
+	Violations []string
+}
+
+func (e *stickerFileError) Error() string {
+	return fmt.Sprintf("not a valid %s sticker: %s", e.Format, strings.Join(e.Violations, "; "))
+}
+
+func (e *stickerFileError) add(format string, args ...any) {
+	e.Violations = append(e.Violations, fmt.Sprintf(format, args...))
+}
+
+// result returns e if there were any violations, nil otherwise.
+func (e *stickerFileError) result() error {
+	if len(e.Violations) == 0 {
+		return nil
+	}
+	return e
+}
+
+// lottieHeader holds the Lottie animation fields Telegram puts limits on.
+type lottieHeader struct {
+	Width     *float64 `json:"w"`
+	Height    *float64 `json:"h"`
+	FrameRate *float64 `json:"fr"`
+	InPoint   *float64 `json:"ip"`
+	OutPoint  *float64 `json:"op"`
+}
+
+// validateTGS checks an animated sticker, a gzipped Lottie animation, the
+// way Telegram does: side x side canvas, 60 fps, at most 3 seconds and at
+// most 64 KB compressed.
+func validateTGS(data []byte, side int) error {
+	verr := &stickerFileError{Format: "TGS"}
+
+	if len(data) > maxAnimatedFileSize {
+		verr.add("file is %.1f KB, the limit is %d KB", float64(len(data))/1024, maxAnimatedFileSize/1024)
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		verr.add("file is not gzip compressed")
+		return verr
+	}
+	raw, err := io.ReadAll(io.LimitReader(r, maxLottieSize+1))
+	if err != nil {
+		verr.add("broken gzip data: %v", err)
+		return verr
+	}
+	if len(raw) > maxLottieSize {
+		verr.add("animation is bigger than %d MB uncompressed", maxLottieSize/1024/1024)
+		return verr
+	}
+
+	var header lottieHeader
+	if err := json.Unmarshal(raw, &header); err != nil {
+		verr.add("not a Lottie animation: %v", err)
+		return verr
+	}
+
+	switch {
+	case header.Width == nil || header.Height == nil:
+		verr.add("canvas size is missing")
+	case *header.Width != float64(side) || *header.Height != float64(side):
+		verr.add("canvas is %gx%g, it must be %dx%d", *header.Width, *header.Height, side, side)
+	}
+
+	if header.FrameRate == nil {
+		verr.add("frame rate is missing")
+	} else if *header.FrameRate != animatedFrameRate {
+		verr.add("frame rate is %g fps, it must be %d fps", *header.FrameRate, animatedFrameRate)
+	}
+
+	switch {
+	case header.InPoint == nil || header.OutPoint == nil:
+		verr.add("animation length is missing")
+	case *header.OutPoint <= *header.InPoint:
+		verr.add("animation has no frames")
+	case header.FrameRate != nil && *header.FrameRate > 0:
+		duration := (*header.OutPoint - *header.InPoint) / *header.FrameRate
+		if duration > maxAnimatedDuration {
+			verr.add("animation is %.2f s long, the limit is %g s", duration, maxAnimatedDuration)
+		}
+	}
+
+	return verr.result()
+}
@@ -0,0 +1,68 @@
+package telegramstickers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateTGS(t *testing.T) {
+	tests := []struct {
+		file string
+		side int
+
+		// One substring per violation, in the order they are listed
+		want []string
+	}{
+		{file: "valid.tgs", side: stickerSide},
+		{file: "wrong_size.tgs", side: 100},
+		{file: "too_big.tgs", side: stickerSide, want: []string{"the limit is 64 KB"}},
+		{file: "fps_30.tgs", side: stickerSide, want: []string{"frame rate is 30 fps"}},
+		{file: "too_long.tgs", side: stickerSide, want: []string{"4.00 s long"}},
+		{file: "not_square.tgs", side: stickerSide, want: []string{"canvas is 512x256"}},
+		{file: "wrong_size.tgs", side: stickerSide, want: []string{"canvas is 100x100"}},
+		{file: "not_gzip.tgs", side: stickerSide, want: []string{"not gzip compressed"}},
+		{file: "everything_wrong.tgs", side: stickerSide, want: []string{
+			"the limit is 64 KB",
+			"canvas is 256x512",
+			"frame rate is 30 fps",
+			"4.00 s long",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%d", tt.file, tt.side), func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = validateTGS(data, tt.side)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("validateTGS() = %v, want nil", err)
+				}
+				return
+			}
+
+			var verr *stickerFileError
+			if !errors.As(err, &verr) {
+				t.Fatalf("validateTGS() = %v, want a *stickerFileError", err)
+			}
+			if len(verr.Violations) != len(tt.want) {
+				t.Fatalf("violations = %q, want %d", verr.Violations, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(verr.Violations[i], want) {
+					t.Errorf("violation %d = %q, want it to mention %q", i+1, verr.Violations[i], want)
+				}
+				if !strings.Contains(verr.Error(), verr.Violations[i]) {
+					t.Errorf("Error() = %q, doesn't list %q", verr.Error(), verr.Violations[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package telegramstickers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	maxVideoFileSize = 256 * 1024
+	maxVideoDuration = 3 * time.Second
+	maxVideoFPS      = 30
+)
+
+// Matroska element IDs, see https://www.matroska.org/technical/elements.html
+const (
+	idEBML            = 0x1A45DFA3
+	idDocType         = 0x4282
+	idSegment         = 0x18538067
+	idInfo            = 0x1549A966
+	idTimecodeScale   = 0x2AD7B1
+	idDuration        = 0x4489
+	idTracks          = 0x1654AE6B
+	idTrackEntry      = 0xAE
+	idTrackNumber     = 0xD7
+	idTrackType       = 0x83
+	idCodecID         = 0x86
+	idDefaultDuration = 0x23E383
+	idVideo           = 0xE0
+	idPixelWidth      = 0xB0
+	idPixelHeight     = 0xBA
+	idCluster         = 0x1F43B675
+	idTimecode        = 0xE7
+	idBlockGroup      = 0xA0
+	idBlock           = 0xA1
+	idSimpleBlock     = 0xA3
+
+	trackTypeVideo = 1
+	trackTypeAudio = 2
+)
+
+// Master elements whose children are read, every other element is a leaf
+// or skipped as a whole
+var webmMasters = map[uint64]bool{
+	idEBML:       true,
+	idSegment:    true,
+	idInfo:       true,
+	idTracks:     true,
+	idTrackEntry: true,
+	idVideo:      true,
+	idCluster:    true,
+	idBlockGroup: true,
+}
+
+var errTruncated = errors.New("file is truncated")
+
+// webmTrack is a track of a WEBM file.
+type webmTrack struct {
+	Number uint64
+	Type   uint64
+	Codec  string
+	Width  uint64
+	Height uint64
+
+	// Duration of a frame as declared by the file, 0 if it doesn't say
+	FrameDuration time.Duration
+}
+
+// webmFrames are the blocks of one track.
+type webmFrames struct {
+	Count       int
+	First, Last time.Duration
+}
+
+// webmInfo is what Telegram checks in a video sticker.
+type webmInfo struct {
+	DocType  string
+	Duration time.Duration
+	Tracks   []webmTrack
+
+	// Blocks by track number
+	Frames map[uint64]*webmFrames
+}
+
+// video returns the first video track, ok is false if there is none.
+func (w *webmInfo) video() (track webmTrack, ok bool) {
+	for _, track := range w.Tracks {
+		if track.Type == trackTypeVideo {
+			return track, true
+		}
+	}
+	return webmTrack{}, false
+}
+
+// fps returns the frame rate of a track, from the frame duration it declares
+// or else from its blocks. It is 0 if the track has fewer than two frames.
+func (w *webmInfo) fps(track webmTrack) float64 {
+	if track.FrameDuration > 0 {
+		return float64(time.Second) / float64(track.FrameDuration)
+	}
+	frames := w.Frames[track.Number]
+	if frames == nil || frames.Count < 2 || frames.Last <= frames.First {
+		return 0
+	}
+	return float64(frames.Count-1) / (frames.Last - frames.First).Seconds()
+}
+
+// hasAudio reports whether the file has an audio track.
+func (w *webmInfo) hasAudio() bool {
+	for _, track := range w.Tracks {
+		if track.Type == trackTypeAudio {
+			return true
+		}
+	}
+	return false
+}
+
+// readVint reads an EBML variable length integer. Element IDs keep their
+// length marker bits, sizes don't. unknown is set for a size of all ones.
+func readVint(data []byte, keepMarker bool) (value uint64, n int, unknown bool, err error) {
+	if len(data) == 0 {
+		return 0, 0, false, errTruncated
+	}
+
+	first := data[0]
+	n = 1
+	for mask := byte(0x80); first&mask == 0; mask >>= 1 {
+		n++
+		if n > 8 {
+			return 0, 0, false, errors.New("invalid EBML number")
+		}
+	}
+	if len(data) < n {
+		return 0, 0, false, errTruncated
+	}
+
+	value = uint64(first)
+	if !keepMarker {
+		value &= uint64(0xFF >> n)
+	}
+	allOnes := value == uint64(0xFF>>n)
+	for _, b := range data[1:n] {
+		value = value<<8 | uint64(b)
+		allOnes = allOnes && b == 0xFF
+	}
+	return value, n, !keepMarker && allOnes, nil
+}
+
+func readUint(data []byte) uint64 {
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+func readFloat(data []byte) (float64, error) {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), nil
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), nil
+	}
+	return 0, fmt.Errorf("invalid float of %d bytes", len(data))
+}
+
+// inspectWEBM reads the header, tracks and block timestamps of a WEBM
+// file. Master elements are walked into rather than read as a whole, which
+// also copes with the unknown sizes streaming encoders write.
+func inspectWEBM(data []byte) (*webmInfo, error) {
+	info := &webmInfo{Frames: make(map[uint64]*webmFrames)}
+	timecodeScale := uint64(1_000_000)
+	var duration float64
+	var clusterTime, lastBlock int64
+	var track *webmTrack
+
+	for pos := 0; pos < len(data); {
+		id, idLen, _, err := readVint(data[pos:], true)
+		if err != nil {
+			return nil, err
+		}
+		size, sizeLen, unknown, err := readVint(data[pos+idLen:], false)
+		if err != nil {
+			return nil, err
+		}
+		pos += idLen + sizeLen
+
+		if id == idTrackEntry {
+			info.Tracks = append(info.Tracks, webmTrack{})
+			track = &info.Tracks[len(info.Tracks)-1]
+		}
+		if webmMasters[id] {
+			continue
+		}
+
+		if unknown {
+			return nil, fmt.Errorf("element %X has unknown size", id)
+		}
+		if size > uint64(len(data)-pos) {
+			return nil, errTruncated
+		}
+		body := data[pos : pos+int(size)]
+		pos += int(size)
+
+		switch id {
+		case idDocType:
+			info.DocType = string(body)
+		case idTimecodeScale:
+			timecodeScale = readUint(body)
+		case idDuration:
+			if duration, err = readFloat(body); err != nil {
+				return nil, err
+			}
+		case idTrackNumber, idTrackType, idCodecID, idDefaultDuration, idPixelWidth, idPixelHeight:
+			if track == nil {
+				return nil, fmt.Errorf("element %X outside of a track", id)
+			}
+			switch id {
+			case idTrackNumber:
+				track.Number = readUint(body)
+			case idDefaultDuration:
+				track.FrameDuration = time.Duration(readUint(body))
+			case idTrackType:
+				track.Type = readUint(body)
+			case idCodecID:
+				track.Codec = string(body)
+			case idPixelWidth:
+				track.Width = readUint(body)
+			case idPixelHeight:
+				track.Height = readUint(body)
+			}
+		case idTimecode:
+			clusterTime = int64(readUint(body))
+		case idSimpleBlock, idBlock:
+			// Track number, then the time relative to the cluster
+			number, n, _, err := readVint(body, false)
+			if err != nil || len(body) < n+2 {
+				return nil, errTruncated
+			}
+			blockTime := clusterTime + int64(int16(binary.BigEndian.Uint16(body[n:])))
+			lastBlock = max(lastBlock, blockTime)
+
+			at := time.Duration(blockTime * int64(timecodeScale))
+			frames := info.Frames[number]
+			if frames == nil {
+				frames = &webmFrames{First: at, Last: at}
+				info.Frames[number] = frames
+			}
+			frames.Count++
+			frames.First, frames.Last = min(frames.First, at), max(frames.Last, at)
+		}
+	}
+
+	// Files written without seeking have no duration, use the last frame instead
+	if duration > 0 {
+		info.Duration = time.Duration(duration * float64(timecodeScale))
+	} else {
+		info.Duration = time.Duration(lastBlock * int64(timecodeScale))
+	}
+	return info, nil
+}
+
+// validateWEBM checks a video sticker the way Telegram does: a VP9 video
+// without audio, at most 3 seconds, 30 fps and 256 KB, whose longer side is
+// side pixels. With square set both sides must be side pixels.
+func validateWEBM(data []byte, side int, square bool) error {
+	verr := &stickerFileError{Format: "WEBM"}
+
+	if len(data) > maxVideoFileSize {
+		verr.add("file is %.1f KB, the limit is %d KB", float64(len(data))/1024, maxVideoFileSize/1024)
+	}
+
+	info, err := inspectWEBM(data)
+	if err != nil {
+		verr.add("can't read the file: %v", err)
+		return verr
+	}
+	if info.DocType != "webm" {
+		verr.add("file is not WEBM")
+		return verr
+	}
+
+	video, ok := info.video()
+	if !ok {
+		verr.add("file has no video track")
+	} else {
+		if video.Codec != "V_VP9" {
+			verr.add("video codec is %s, it must be VP9", video.Codec)
+		}
+		w, h := int(video.Width), int(video.Height)
+		if square && (w != side || h != side) {
+			verr.add("video is %dx%d, it must be %dx%d", w, h, side, side)
+		} else if !square && (max(w, h) != side || min(w, h) > side) {
+			verr.add("video is %dx%d, one side must be %d and the other at most %d", w, h, side, side)
+		}
+		if fps := info.fps(video); math.Round(fps) > maxVideoFPS {
+			verr.add("frame rate is %.0f fps, the limit is %d fps", fps, maxVideoFPS)
+		}
+	}
+
+	if info.hasAudio() {
+		verr.add("file has an audio track, remove it")
+	}
+
+	if info.Duration > maxVideoDuration {
+		verr.add("video is %.2f s long, the limit is %g s", info.Duration.Seconds(), maxVideoDuration.Seconds())
+	}
+
+	return verr.result()
+}
@@ -0,0 +1,61 @@
+package telegramstickers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateWEBM(t *testing.T) {
+	tests := []struct {
+		file   string
+		side   int
+		square bool
+
+		// One substring per violation, in the order they are listed
+		want []string
+	}{
+		{file: "valid.webm", side: stickerSide},
+		{file: "valid.webm", side: stickerSide, square: true},
+		{file: "valid.webm", side: customEmojiSide, square: true, want: []string{"video is 512x512, it must be 100x100"}},
+		{file: "fps_60.webm", side: stickerSide, want: []string{"frame rate is 60 fps"}},
+		{file: "fps_60_declared.webm", side: stickerSide, want: []string{"frame rate is 60 fps"}},
+		{file: "too_long.webm", side: stickerSide, want: []string{"4.00 s long"}},
+		{file: "too_big.webm", side: stickerSide, want: []string{"the limit is 256 KB"}},
+		{file: "audio_track.webm", side: stickerSide, want: []string{"audio track"}},
+		{file: "truncated_header.webm", side: stickerSide, want: []string{"file is truncated"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%d", tt.file, tt.side), func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = validateWEBM(data, tt.side, tt.square)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("validateWEBM() = %v, want nil", err)
+				}
+				return
+			}
+
+			var verr *stickerFileError
+			if !errors.As(err, &verr) {
+				t.Fatalf("validateWEBM() = %v, want a *stickerFileError", err)
+			}
+			if len(verr.Violations) != len(tt.want) {
+				t.Fatalf("violations = %q, want %d", verr.Violations, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(verr.Violations[i], want) {
+					t.Errorf("violation %d = %q, want it to mention %q", i+1, verr.Violations[i], want)
+				}
+			}
+		})
+	}
+}